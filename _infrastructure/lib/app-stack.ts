@@ -18,7 +18,84 @@ export class AppStack extends Stack {
             },
            removalPolicy: RemovalPolicy.DESTROY,
         })
-        new Key(this, "Key", {
+        const authorizationCodesTable = new Table(this, "AuthorizationCodesTable", {
+            billingMode: BillingMode.PAY_PER_REQUEST,
+            tableName: 'authorization_codes',
+            partitionKey: {
+                type: AttributeType.STRING,
+                name: 'code'
+            },
+            timeToLiveAttribute: 'expiresAt',
+            removalPolicy: RemovalPolicy.DESTROY,
+        })
+        const refreshTokensTable = new Table(this, "RefreshTokensTable", {
+            billingMode: BillingMode.PAY_PER_REQUEST,
+            tableName: 'refresh_tokens',
+            partitionKey: {
+                type: AttributeType.STRING,
+                name: 'familyId'
+            },
+            timeToLiveAttribute: 'expiresAt',
+            removalPolicy: RemovalPolicy.DESTROY,
+        })
+        const signingKeysTable = new Table(this, "SigningKeysTable", {
+            billingMode: BillingMode.PAY_PER_REQUEST,
+            tableName: 'signing_keys',
+            partitionKey: {
+                type: AttributeType.STRING,
+                name: 'keyId'
+            },
+            timeToLiveAttribute: 'expiresAt',
+            removalPolicy: RemovalPolicy.DESTROY,
+        })
+        const revokedTokensTable = new Table(this, "RevokedTokensTable", {
+            billingMode: BillingMode.PAY_PER_REQUEST,
+            tableName: 'revoked_tokens',
+            partitionKey: {
+                type: AttributeType.STRING,
+                name: 'jti'
+            },
+            timeToLiveAttribute: 'expiresAt',
+            removalPolicy: RemovalPolicy.DESTROY,
+        })
+        const accessTokensTable = new Table(this, "AccessTokensTable", {
+            billingMode: BillingMode.PAY_PER_REQUEST,
+            tableName: 'access_tokens',
+            partitionKey: {
+                type: AttributeType.STRING,
+                name: 'tokenHash'
+            },
+            timeToLiveAttribute: 'expiresAt',
+            removalPolicy: RemovalPolicy.DESTROY,
+        })
+        const usersTable = new Table(this, "UsersTable", {
+            billingMode: BillingMode.PAY_PER_REQUEST,
+            tableName: 'users',
+            partitionKey: {
+                type: AttributeType.STRING,
+                name: 'subject'
+            },
+            removalPolicy: RemovalPolicy.DESTROY,
+        })
+        usersTable.addGlobalSecondaryIndex({
+            indexName: 'username-index',
+            partitionKey: {
+                type: AttributeType.STRING,
+                name: 'username'
+            },
+        })
+        const sessionsTable = new Table(this, "SessionsTable", {
+            billingMode: BillingMode.PAY_PER_REQUEST,
+            tableName: 'sessions',
+            partitionKey: {
+                type: AttributeType.STRING,
+                name: 'sessionHash'
+            },
+            timeToLiveAttribute: 'expiresAt',
+            removalPolicy: RemovalPolicy.DESTROY,
+        })
+        // An HMAC key, whose key material never leaves KMS. Resource servers validate its tokens at /introspect.
+        const key = new Key(this, "Key", {
             keySpec: KeySpec.HMAC_256,
             keyUsage: KeyUsage.GENERATE_VERIFY_MAC,
         });
@@ -26,12 +103,31 @@ export class AppStack extends Stack {
             entry: __dirname + "/../../cmd/idp-idp/main.go",
             moduleDir: __dirname + "/../../go.mod",
             functionName: "idp-idp",
+            environment: {
+                SIGNING_KMS_KEY_ID: key.keyId,
+            },
         });
         table.grantFullAccess(fn);
+        authorizationCodesTable.grantReadWriteData(fn);
+        refreshTokensTable.grantReadWriteData(fn);
+        signingKeysTable.grantReadWriteData(fn);
+        revokedTokensTable.grantReadWriteData(fn);
+        accessTokensTable.grantReadWriteData(fn);
+        usersTable.grantReadWriteData(fn);
+        sessionsTable.grantReadWriteData(fn);
+        key.grant(fn, 'kms:GenerateMac', 'kms:VerifyMac');
         const httpApi = new HttpApi(this, 'HttpApi', {
             apiName: 'idp-idp',
             description: 'This is the API for the IDP idp',
         });
+        fn.addEnvironment('ISSUER', httpApi.apiEndpoint);
+        // Clients without audiences receive access tokens for the API of the idp itself, like /userinfo.
+        fn.addEnvironment('AUDIENCE', httpApi.apiEndpoint);
+        httpApi.addRoutes({
+            path: '/authorize',
+            methods: [HttpMethod.GET, HttpMethod.POST],
+            integration: new HttpLambdaIntegration('Integration', fn)
+        });
         httpApi.addRoutes({
             path: '/token',
             methods: [HttpMethod.POST],
@@ -42,6 +138,41 @@ export class AppStack extends Stack {
             methods: [HttpMethod.POST],
             integration: new HttpLambdaIntegration('Integration', fn)
         });
+        httpApi.addRoutes({
+            path: '/revoke',
+            methods: [HttpMethod.POST],
+            integration: new HttpLambdaIntegration('Integration', fn)
+        });
+        httpApi.addRoutes({
+            path: '/.well-known/jwks.json',
+            methods: [HttpMethod.GET],
+            integration: new HttpLambdaIntegration('Integration', fn)
+        });
+        httpApi.addRoutes({
+            path: '/.well-known/oauth-authorization-server',
+            methods: [HttpMethod.GET],
+            integration: new HttpLambdaIntegration('Integration', fn)
+        });
+        httpApi.addRoutes({
+            path: '/.well-known/openid-configuration',
+            methods: [HttpMethod.GET],
+            integration: new HttpLambdaIntegration('Integration', fn)
+        });
+        httpApi.addRoutes({
+            path: '/userinfo',
+            methods: [HttpMethod.GET, HttpMethod.POST],
+            integration: new HttpLambdaIntegration('Integration', fn)
+        });
+        httpApi.addRoutes({
+            path: '/login',
+            methods: [HttpMethod.GET, HttpMethod.POST],
+            integration: new HttpLambdaIntegration('Integration', fn)
+        });
+        httpApi.addRoutes({
+            path: '/logout',
+            methods: [HttpMethod.POST],
+            integration: new HttpLambdaIntegration('Integration', fn)
+        });
         new CfnOutput(this, 'ApiUrl', {
             value: httpApi.apiEndpoint,
             key: 'ApiUrl',
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	idp "github.com/daschaa/open-idp/internal/idp"
+	"github.com/daschaa/open-idp/internal/kmslocal"
 	"github.com/daschaa/open-idp/internal/repository"
 	"github.com/gorilla/mux"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
@@ -17,17 +21,78 @@ func (c SystemClock) Now() time.Time {
 
 func main() {
 	clientRepository := repository.NewDynamoDbClientRepository(repository.NewLocalDynamoDbClient())
-	_, err := clientRepository.SaveClient("1234567890", "client_secret")
+	_, err := clientRepository.SaveClient(repository.Client{
+		ClientId:           "1234567890",
+		Name:               "Example",
+		ClientSecret:       "client_secret",
+		RedirectUris:       []string{"http://localhost:3000/callback"},
+		Scopes:             []string{"read:example", "openid", "profile", "email"},
+		AllowIntrospection: true,
+	})
 	if err != nil {
 		log.Fatalf("Failed to save client: %v", err)
 	}
 
-	signingKey := []byte("your_secret_key")
+	// The local user alice logs in with the password "password" at http://localhost:8080/login.
+	passwordHash, err := idp.HashPassword("password")
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+	userRepository := repository.NewSimpleUserRepository()
+	err = userRepository.SaveUser(repository.User{
+		Subject:       "alice",
+		Username:      "alice",
+		Name:          "Alice Example",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		PasswordHash:  passwordHash,
+	})
+	if err != nil {
+		log.Fatalf("Failed to save user: %v", err)
+	}
+
+	// The local KMS stand-in keeps the signing key out of the server, like KMS does in AWS.
+	// It listens before the server starts, because the public key of the signing key is fetched right away.
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Failed to generate signing key: %v", err)
+	}
+	kms := kmslocal.New()
+	kms.AddRsaKey("local-signing-key", privateKey)
+	listener, err := net.Listen("tcp", ":4000")
+	if err != nil {
+		log.Fatalf("Local KMS failed to start: %v", err)
+	}
+	go func() {
+		log.Fatal(http.Serve(listener, kms))
+	}()
+	signingKey, err := idp.NewKmsSigningKey("local-signing-key", idp.NewLocalKmsClient("http://localhost:4000"), "local-signing-key")
+	if err != nil {
+		log.Fatalf("Failed to load signing key: %v", err)
+	}
 
 	router := mux.NewRouter()
-	server := idp.New(clientRepository, idp.WithSigningKey(&signingKey))
+	server, err := idp.New(clientRepository,
+		idp.WithKey(signingKey),
+		idp.WithIssuer("http://localhost:8080"),
+		idp.WithAudience("http://localhost:8080"),
+		idp.WithScopesSupported([]string{"read:example", "openid", "profile", "email"}),
+		idp.WithUserRepository(userRepository),
+		idp.WithLogin("/login"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+	router.HandleFunc("/authorize", server.AuthorizeHandler)
 	router.HandleFunc("/token", server.TokenHandler)
 	router.HandleFunc("/introspect", server.IntrospectHandler)
+	router.HandleFunc("/revoke", server.RevokeHandler)
+	router.HandleFunc("/userinfo", server.UserInfoHandler)
+	router.HandleFunc("/login", server.LoginHandler)
+	router.HandleFunc("/logout", server.LogoutHandler)
+	router.HandleFunc("/.well-known/jwks.json", server.JwksHandler)
+	router.HandleFunc("/.well-known/oauth-authorization-server", server.MetadataHandler)
+	router.HandleFunc("/.well-known/openid-configuration", server.OpenIdConfigurationHandler)
 
 	if err := http.ListenAndServe(":8080", router); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
@@ -7,16 +7,68 @@ import (
 	idp "github.com/daschaa/open-idp/internal/idp"
 	repository "github.com/daschaa/open-idp/internal/repository"
 	"github.com/gorilla/mux"
+	"log"
+	"os"
 )
 
 func main() {
 	fmt.Println("Starting IDP idp")
 	clientRepository := repository.NewDynamoDbClientRepository(repository.NewDynamoDbClient())
+	authorizationCodeRepository := repository.NewDynamoDbAuthorizationCodeRepository(repository.NewDynamoDbClient())
+	refreshTokenRepository := repository.NewDynamoDbRefreshTokenRepository(repository.NewDynamoDbClient())
+	revocationRepository := repository.NewDynamoDbRevocationRepository(repository.NewDynamoDbClient())
+	tokenRepository := repository.NewDynamoDbTokenRepository(repository.NewDynamoDbClient())
+	userRepository := repository.NewDynamoDbUserRepository(repository.NewDynamoDbClient())
+	sessionRepository := repository.NewDynamoDbSessionRepository(repository.NewDynamoDbClient())
+
+	options := []idp.ServerOption{
+		idp.WithAuthorizationCodeRepository(authorizationCodeRepository),
+		idp.WithRefreshTokenRepository(refreshTokenRepository),
+		idp.WithRevocationRepository(revocationRepository),
+		idp.WithTokenRepository(tokenRepository),
+		idp.WithUserRepository(userRepository),
+		idp.WithSessionRepository(sessionRepository),
+		idp.WithLogin("/login"),
+	}
+	// Access tokens and ID tokens require an issuer. Clients without Audiences receive tokens for the default audience.
+	issuer, ok := os.LookupEnv("ISSUER")
+	if !ok {
+		log.Fatal("The ISSUER environment variable is required")
+	}
+	options = append(options, idp.WithIssuer(issuer))
+	if audience, ok := os.LookupEnv("AUDIENCE"); ok {
+		options = append(options, idp.WithAudience(audience))
+	}
+	// Tokens are signed with the HMAC KMS key of the stack, so the key material never enters the Lambda.
+	// Without a KMS signing key, the server falls back to a key ring that is stored in DynamoDB,
+	// whose keys are envelope encrypted with the symmetric KMS key of KEY_RING_KMS_KEY_ID.
+	if kmsKeyId, ok := os.LookupEnv("SIGNING_KMS_KEY_ID"); ok {
+		options = append(options, idp.WithKey(idp.NewKmsHmacKey(kmsKeyId, idp.NewKmsClient(), kmsKeyId)))
+	} else if kmsKeyId, ok := os.LookupEnv("KEY_RING_KMS_KEY_ID"); ok {
+		keyRing := idp.NewKeyRing(repository.NewDynamoDbKeyRepository(repository.NewDynamoDbClient()), idp.KeyRingConfig{
+			KmsClient: idp.NewKmsClient(),
+			KmsKeyId:  kmsKeyId,
+		})
+		options = append(options, idp.WithKeySet(keyRing))
+	} else {
+		log.Fatal("Either the SIGNING_KMS_KEY_ID or the KEY_RING_KMS_KEY_ID environment variable is required")
+	}
 
 	router := mux.NewRouter()
-	server := idp.New(clientRepository)
+	server, err := idp.New(clientRepository, options...)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+	router.HandleFunc("/authorize", server.AuthorizeHandler)
 	router.HandleFunc("/token", server.TokenHandler)
 	router.HandleFunc("/introspect", server.IntrospectHandler)
+	router.HandleFunc("/revoke", server.RevokeHandler)
+	router.HandleFunc("/userinfo", server.UserInfoHandler)
+	router.HandleFunc("/login", server.LoginHandler)
+	router.HandleFunc("/logout", server.LogoutHandler)
+	router.HandleFunc("/.well-known/jwks.json", server.JwksHandler)
+	router.HandleFunc("/.well-known/oauth-authorization-server", server.MetadataHandler)
+	router.HandleFunc("/.well-known/openid-configuration", server.OpenIdConfigurationHandler)
 
 	lambda.Start(httpadapter.NewV2(router).ProxyWithContext)
 }
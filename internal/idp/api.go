@@ -8,28 +8,58 @@ import (
 	"fmt"
 	"github.com/daschaa/open-idp/internal/repository"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/thanhpk/randstr"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 )
 
 type introspectRequest struct {
-	Token *string `json:"token"`
+	Token         *string `json:"token"`
+	TokenTypeHint string  `json:"token_type_hint"`
+	ClientId      string  `json:"client_id"`
+	ClientSecret  string  `json:"client_secret"`
+	AuthMethod    string  `json:"-"`
+	BearerToken   string  `json:"-"`
 }
 
 type tokenRequest struct {
-	ClientId     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
-	GrantType    string `json:"grant_type"`
+	ClientId     string       `json:"client_id"`
+	ClientSecret string       `json:"client_secret"`
+	GrantType    string       `json:"grant_type"`
+	Code         string       `json:"code"`
+	RedirectUri  string       `json:"redirect_uri"`
+	CodeVerifier string       `json:"code_verifier"`
+	RefreshToken string       `json:"refresh_token"`
+	Username     string       `json:"username"`
+	Password     string       `json:"password"`
+	Scope        string       `json:"scope"`
+	Resource     resourceList `json:"resource"`
+	AuthMethod   string       `json:"-"`
 }
 
 type ServerOption func(c *Server)
 
 // Server represents the IdP server with its dependencies.
 type Server struct {
-	clientRepository *repository.ClientRepository
-	clock            repository.Clock
-	signingKey       *[]byte
+	clientRepository            *repository.ClientRepository
+	authorizationCodeRepository repository.AuthorizationCodeRepository
+	refreshTokenRepository      repository.RefreshTokenRepository
+	revocationRepository        repository.RevocationRepository
+	tokenRepository             repository.TokenRepository
+	clock                       repository.Clock
+	keys                        KeySet
+	issuer                      string
+	accessTokenLifetime         time.Duration
+	scopesSupported             []string
+	userRepository              repository.UserRepository
+	sessionRepository           repository.SessionRepository
+	authenticator               Authenticator
+	maxFailedLogins             int
+	lockoutDuration             time.Duration
+	implicitGrant               bool
+	passwordGrant               bool
+	audience                    string
 }
 
 type systemClock struct{}
@@ -39,106 +69,453 @@ func (c systemClock) Now() time.Time {
 }
 
 func (s *Server) validateClient(clientId string, clientSecret string) (bool, error) {
-	client, err := (*s.clientRepository).GetClient(clientId)
+	ok, err := (*s.clientRepository).VerifyClientSecret(clientId, clientSecret, s.clock.Now())
 	if err != nil {
 		return false, err
 	}
 
-	if !(client.ClientSecret == clientSecret) {
+	if !ok {
 		return false, errors.New("Secret does not match")
 	}
 
 	return true, nil
 }
 
+// authenticateClient authenticates the client of a token, revocation or introspection request.
+// Public clients, which have the TokenEndpointAuthMethod none, are identified by their client id only with the authentication method none
+// and rely on PKCE to prove that they initiated the authorization request.
+// Clients with a TokenEndpointAuthMethod must use that method. Disabled clients can not authenticate.
+// It returns the client if it is authenticated.
+func (s *Server) authenticateClient(clientId string, clientSecret string, authMethod string) (*repository.Client, bool) {
+	client, err := (*s.clientRepository).GetClient(clientId)
+	if err != nil || client.Disabled {
+		return nil, false
+	}
+	if client.TokenEndpointAuthMethod != "" && client.TokenEndpointAuthMethod != authMethod {
+		return nil, false
+	}
+
+	ok, _ := s.validateClient(clientId, clientSecret)
+	return client, ok
+}
+
 // IntrospectHandler handles the introspection of a token.
-// It decodes the incoming request, validates the token, and returns the token's active status and subject.
+// It decodes the incoming request, authenticates the caller, validates the token, and returns the token's active status
+// and its metadata as described in RFC 7662 section 2.2.
+//
+// The caller must authenticate as a client that is allowed to introspect tokens, either with its client credentials
+// like at the TokenHandler, or with an access token of such a client in a Bearer Authorization header.
+// The access token must be issued to the client itself, like with the client credentials grant, so that its sub is its client_id.
+// Access tokens of end users are rejected.
 //
-// If the request body is invalid or the token is nil, it responds with a 400 Bad Request status.
-// If the token is invalid, it responds with a JSON object indicating the token is inactive.
-// If the token is valid, it verifies the token's expiration and responds with the token's active status and subject.
+// If the request body is invalid or the token is nil, it responds with a 400 Bad Request status and an invalid_request error.
+// If the caller is not authenticated, it responds with a 401 Unauthorized status and an invalid_client error.
+// If the caller is not allowed to introspect tokens, it responds with a 400 Bad Request status and an unauthorized_client error.
+// If the token is invalid, expired, not yet valid, revoked or its client is unknown or disabled, it responds with a JSON object indicating the token is inactive.
+// If the caller is a resource server with a Resource, tokens that are not issued for that audience are reported as inactive as well,
+// so that a token for one API can not be replayed against another.
+// Opaque access tokens are resolved through the TokenRepository, JWTs are verified with the keys of the server.
+// If the token is active, it responds with all claims of the token, including custom claims, together with the client_id
+// of the client record and the token_type. If the subject of the token is a user, the username of the user is returned as well.
 func (s *Server) IntrospectHandler(w http.ResponseWriter, r *http.Request) {
-	request := introspectRequest{}
-	err := json.NewDecoder(r.Body).Decode(&request)
+	request, err := parseIntrospectRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	if err != nil || request.Token == nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	caller, err := s.authorizeIntrospection(request)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(*request.Token, claims, func(token *jwt.Token) (interface{}, error) {
-		return *s.signingKey, nil
-	})
+
 	w.Header().Set("Content-Type", "application/json")
-	if !token.Valid {
+	response, active := s.introspect(*request.Token)
+	if active && caller.Resource != "" && !hasAudience(response, caller.Resource) {
+		active = false
+	}
+	if !active {
 		json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
 		return
 	}
 
-	subject := claims["sub"]
-	client, err := (*s.clientRepository).GetClient(fmt.Sprintf("%s", subject))
+	if !isClientToken(response) {
+		subject, _ := response["sub"].(string)
+		user, err := s.userRepository.GetUser(subject)
+		var notFound repository.UserNotFound
+		if err != nil && !errors.As(err, &notFound) {
+			writeError(w, r, err)
+			return
+		}
+		if err == nil {
+			response["username"] = user.Username
+		}
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+}
+
+// isPublicClient reports whether the client is a public client with the TokenEndpointAuthMethod none.
+// Public clients can not keep credentials, so they may not introspect tokens even with AllowIntrospection.
+func (s *Server) isPublicClient(clientId string) bool {
+	client, err := (*s.clientRepository).GetClient(clientId)
+	return err != nil || client.TokenEndpointAuthMethod == repository.AuthMethodNone
+}
+
+// authenticateGrant authenticates the client of a token request and checks that the client may use the grant type.
+func (s *Server) authenticateGrant(request tokenRequest) (*repository.Client, error) {
+	client, ok := s.authenticateClient(request.ClientId, request.ClientSecret, request.AuthMethod)
+	if !ok {
+		return nil, NewError(ErrorInvalidClient, "Client authentication failed")
+	}
+	// RFC 6749 section 4.4 restricts the client credentials grant to confidential clients, whatever their metadata says.
+	if request.GrantType == repository.GrantTypeClientCredentials && request.AuthMethod == repository.AuthMethodNone {
+		return nil, NewError(ErrorUnauthorizedClient, "Public clients can not use the client credentials grant")
+	}
+	if !client.AllowsGrantType(request.GrantType) {
+		return nil, NewError(ErrorUnauthorizedClient, "The client is not allowed to use the grant type")
+	}
+	return client, nil
+}
+
+// authorizeIntrospection authenticates the caller of the introspection endpoint, checks that it may introspect tokens
+// and returns its client.
+func (s *Server) authorizeIntrospection(request introspectRequest) (*repository.Client, error) {
+	clientId := request.ClientId
+	if request.BearerToken != "" {
+		introspection, active := s.introspect(request.BearerToken)
+		if !active {
+			return nil, NewError(ErrorInvalidClient, "Client authentication failed")
+		}
+		// Only tokens the client obtained for itself, like with the client credentials grant, authenticate the client,
+		// and not the access tokens of its end users, who must not be able to introspect tokens on its behalf.
+		clientId, _ = introspection["client_id"].(string)
+		if subject, _ := introspection["sub"].(string); subject != clientId || s.isPublicClient(clientId) {
+			return nil, NewError(ErrorInvalidClient, "Client authentication failed")
+		}
+	} else if _, ok := s.authenticateClient(request.ClientId, request.ClientSecret, request.AuthMethod); !ok || request.AuthMethod == repository.AuthMethodNone {
+		return nil, NewError(ErrorInvalidClient, "Client authentication failed")
+	}
+
+	client, err := (*s.clientRepository).GetClient(clientId)
+	if err != nil || client.Disabled {
+		return nil, NewError(ErrorInvalidClient, "Client authentication failed")
+	}
+	if !client.AllowIntrospection {
+		return nil, NewError(ErrorUnauthorizedClient, "Client is not allowed to introspect tokens")
+	}
+	return client, nil
+}
+
+// introspect returns the introspection response for an active token.
+func (s *Server) introspect(tokenString string) (map[string]interface{}, bool) {
+	claims, err := s.tokenClaims(tokenString)
 	if err != nil {
-		json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
-		return
+		return nil, false
 	}
 
-	active := claims.VerifyExpiresAt(s.clock.Now().Unix(), true)
+	now := s.clock.Now().Unix()
+	if !claims.VerifyExpiresAt(now, true) || !claims.VerifyNotBefore(now, false) || s.isRevoked(claims) {
+		return nil, false
+	}
 
-	if !active {
-		json.NewEncoder(w).Encode(map[string]interface{}{"active": active})
-		return
+	client, err := (*s.clientRepository).GetClient(tokenClientId(claims))
+	if err != nil || client.Disabled {
+		return nil, false
 	}
 
-	err = json.NewEncoder(w).Encode(map[string]interface{}{"active": active, "sub": client.ClientId})
+	response := map[string]interface{}{}
+	for name, value := range claims {
+		response[name] = value
+	}
+	response["active"] = true
+	response["client_id"] = client.ClientId
+	response["token_type"] = "Bearer"
+	return response, true
+}
+
+// isClientToken reports whether the client obtained the introspected token for itself, like with the client credentials
+// grant. The subject of such a token is the client id and not a user, even if a user happens to have the same subject.
+func isClientToken(introspection map[string]interface{}) bool {
+	subject, _ := introspection["sub"].(string)
+	return subject == introspection["client_id"]
+}
+
+// tokenClientId returns the client of a token, which is the client_id claim or, for tokens without it, the subject.
+func tokenClientId(claims jwt.MapClaims) string {
+	clientId, ok := claims["client_id"].(string)
+	if !ok {
+		clientId = fmt.Sprintf("%s", claims["sub"])
+	}
+	return clientId
 }
 
 // TokenHandler handles the generation of a new token.
 // It decodes the incoming request, validates the client credentials, and returns a new token.
+// The request may be sent as form-encoded or JSON body, and the client may authenticate
+// with client_secret_basic or client_secret_post.
+// The client credentials, authorization code, refresh token and password grant types are supported.
+// The password grant of RFC 6749 section 4.3 is only available if it is enabled with WithPasswordGrant,
+// and then only to clients with AllowPasswordGrant. Failed attempts count towards the lockout of the user like failed logins.
+// The granted scope is returned in the response and in the scope claim of the access token.
+// The audiences of the requested resources, or all allowed audiences of the client, are set as aud claim.
+// If the authorization code was issued for the openid scope, an ID token of OpenID Connect is returned as well.
+// Access tokens follow the JWT profile of RFC 9068. They expire after the AccessTokenLifetime of the client,
+// or after the default lifetime of the server, which is returned in seconds as expires_in.
+// A refresh token is issued next to the access token for the authorization code, refresh token and password grants,
+// but not for the client credentials grant, as recommended by RFC 6749 section 4.4.3.
+// The response must not be cached, so it is sent with Cache-Control: no-store as required by RFC 6749 section 5.1.
 //
-// If the request body is invalid, it responds with a 400 Bad Request status.
-// If the grant type is unsupported, it responds with a 400 Bad Request status.
-// If the client is not authorized, it responds with a 401 Unauthorized status.
+// Errors are returned as JSON body as described in RFC 6749 section 5.2.
+// If the request body is invalid, it responds with a 400 Bad Request status and an invalid_request error.
+// If the client uses more than one authentication method, it responds with a 400 Bad Request status and an invalid_request error.
+// If the grant type is unsupported, it responds with a 400 Bad Request status and an unsupported_grant_type error.
+// If the client is not authorized or disabled, it responds with a 401 Unauthorized status and an invalid_client error.
+// If the authorization code or its code verifier is invalid, it responds with a 400 Bad Request status and an invalid_grant error.
+// If the refresh token is invalid, expired, revoked or was already used, it responds with a 400 Bad Request status and an invalid_grant error.
+// If the client is not allowed to use the password grant, or the grant type is not one of the GrantTypes of the client,
+// it responds with a 400 Bad Request status and an unauthorized_client error.
+// If the username or password is wrong, or the user is locked, it responds with a 400 Bad Request status and an invalid_grant error.
+// If the requested scope is not allowed for the client, or exceeds the scope of the refresh token,
+// it responds with a 400 Bad Request status and an invalid_scope error.
+// If a requested resource is invalid or not allowed for the client, it responds with a 400 Bad Request status
+// and an invalid_target error as described in RFC 8707.
 // If the token generation is successful, it responds with the token and its details.
 func (s *Server) TokenHandler(w http.ResponseWriter, r *http.Request) {
-	request := tokenRequest{}
-	err := json.NewDecoder(r.Body).Decode(&request)
-
+	request, err := parseTokenRequest(r)
 	if err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	if request.GrantType != "client_credentials" {
-		http.Error(w, "Unsupported grant type", http.StatusBadRequest)
+	var client *repository.Client
+	var code *repository.AuthorizationCode
+	var subject, scope, refreshToken string
+	var audiences []string
+	switch request.GrantType {
+	case "client_credentials":
+		client, err = s.authenticateGrant(request)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		scope, err = grantScope(request.Scope, client.Scopes)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		audiences, err = grantAudience(request.Resource, client.Audiences)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		subject = request.ClientId
+	case "authorization_code":
+		client, err = s.authenticateGrant(request)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		pending, err := s.authorizationCodeRepository.GetAuthorizationCode(request.Code)
+		if err != nil {
+			writeError(w, r, NewError(ErrorInvalidGrant, "Invalid authorization code"))
+			return
+		}
+		// The access token may be restricted to some of the resources of the authorization request,
+		// the refresh token keeps all of them. The resource is checked before the code is redeemed,
+		// so that a rejected request does not use it up.
+		audiences, err = grantAudience(request.Resource, pending.Audiences)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		var ok bool
+		code, ok = s.redeemAuthorizationCode(request)
+		if !ok {
+			writeError(w, r, NewError(ErrorInvalidGrant, "Invalid authorization code"))
+			return
+		}
+		subject = code.Subject
+		scope = code.Scope
+		refreshToken, err = s.issueRefreshToken(client, code.Subject, code.Scope, code.Audiences)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	case "refresh_token":
+		client, err = s.authenticateGrant(request)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		family, ok := s.refreshTokenFamily(request.ClientId, request.RefreshToken)
+		if !ok {
+			writeError(w, r, NewError(ErrorInvalidGrant, "Invalid refresh token"))
+			return
+		}
+		// The access token may be narrowed to a part of the original scope, the refresh token keeps the original scope.
+		// Both are checked before the refresh token is redeemed, so that a rejected request does not use it up.
+		scope, err = grantScope(request.Scope, strings.Fields(family.Scope))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		audiences, err = grantAudience(request.Resource, family.Audiences)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next, ok := s.rotateRefreshToken(family, request.RefreshToken)
+		if !ok {
+			writeError(w, r, NewError(ErrorInvalidGrant, "Invalid refresh token"))
+			return
+		}
+		subject = family.Subject
+		refreshToken = next
+	case "password":
+		if !s.passwordGrant {
+			writeError(w, r, NewError(ErrorUnsupportedGrantType, "Unsupported grant type"))
+			return
+		}
+		client, err = s.authenticateGrant(request)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !client.AllowPasswordGrant {
+			writeError(w, r, NewError(ErrorUnauthorizedClient, "The client is not allowed to use the password grant"))
+			return
+		}
+		if request.Username == "" || request.Password == "" {
+			writeError(w, r, NewError(ErrorInvalidRequest, "The username and password are required"))
+			return
+		}
+		user, err := s.authenticateUser(request.Username, request.Password)
+		if errors.Is(err, errInvalidCredentials) {
+			writeError(w, r, NewError(ErrorInvalidGrant, "Invalid username or password"))
+			return
+		}
+		if errors.Is(err, errUserLocked) {
+			writeError(w, r, NewError(ErrorInvalidGrant, "Too many failed logins"))
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		scope, err = grantScope(request.Scope, client.Scopes)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		audiences, err = grantAudience(request.Resource, client.Audiences)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		subject = user.Subject
+		refreshToken, err = s.issueRefreshToken(client, subject, scope, audiences)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	default:
+		writeError(w, r, NewError(ErrorUnsupportedGrantType, "Unsupported grant type"))
 		return
 	}
 
-	ok, err := s.validateClient(request.ClientId, request.ClientSecret)
-
-	if !ok {
-		http.Error(w, "Client is not authorized", http.StatusUnauthorized)
+	lifetime := s.clientAccessTokenLifetime(client)
+	token, err := s.issueAccessToken(client, subject, scope, audiences, lifetime)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":   request.ClientId,
-		"exp":   s.clock.Now().Add(time.Hour).Unix(),
-		"scope": "read:example",
-	}).SignedString(*s.signingKey)
+	response := map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int64(lifetime / time.Second),
+	}
+	if code != nil && slices.Contains(strings.Fields(scope), scopeOpenId) {
+		idToken, err := s.issueIdToken(client, code, token)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		response["id_token"] = idToken
+	}
+	if scope != "" {
+		response["scope"] = scope
+	}
+	if refreshToken != "" {
+		response["refresh_token"] = refreshToken
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(map[string]string{"access_token": token, "token_type": "Bearer", "expires_in": "3600"})
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 }
 
-// WithSigningKey is a ServerOption that sets the signing key for the server.
-// The signing key is used to sign the tokens generated by the server.
+// WithSigningKey is a ServerOption that sets a symmetric HS256 signing key for the server.
+// The signing key is used to sign the tokens generated by the server. Its key id is "default".
 func WithSigningKey(key *[]byte) ServerOption {
 	return func(s *Server) {
-		s.signingKey = key
+		s.keys = staticKeySet{key: NewHmacKey("default", *key)}
+	}
+}
+
+// WithKey is a ServerOption that sets the key, which signs the tokens generated by the server.
+// The public part of asymmetric keys is published by the JwksHandler.
+func WithKey(key Key) ServerOption {
+	return func(s *Server) {
+		s.keys = staticKeySet{key: key}
+	}
+}
+
+// WithKeySet is a ServerOption that sets the keys of the server, for example a KeyRing,
+// which rotates the signing key and shares it between all server instances.
+func WithKeySet(keys KeySet) ServerOption {
+	return func(s *Server) {
+		s.keys = keys
+	}
+}
+
+// WithIssuer is a ServerOption that sets the issuer identifier of the server, which is set as iss claim of all tokens.
+// It should be the HTTPS URL under which the server is reachable. The issuer is required by New.
+// A trailing slash is removed, so that the iss claim matches the issuer of the metadata.
+func WithIssuer(issuer string) ServerOption {
+	return func(s *Server) {
+		s.issuer = strings.TrimSuffix(issuer, "/")
+	}
+}
+
+// WithAudience is a ServerOption that sets the resource identifier, which is set as aud claim of access tokens
+// that are issued without a requested resource to clients without Audiences.
+// Without it, the issuer of the server is used, because RFC 9068 requires the aud claim.
+func WithAudience(audience string) ServerOption {
+	return func(s *Server) {
+		s.audience = audience
+	}
+}
+
+// WithScopesSupported is a ServerOption that sets the scopes that are advertised by the MetadataHandler.
+// Clients are still restricted to their own allowed scopes.
+func WithScopesSupported(scopes []string) ServerOption {
+	return func(s *Server) {
+		s.scopesSupported = scopes
+	}
+}
+
+// WithAccessTokenLifetime is a ServerOption that sets the default lifetime of access tokens.
+// Clients may override it with their own AccessTokenLifetime. By default, access tokens are valid for one hour.
+func WithAccessTokenLifetime(lifetime time.Duration) ServerOption {
+	return func(s *Server) {
+		s.accessTokenLifetime = lifetime
 	}
 }
 
@@ -150,19 +527,134 @@ func WithClock(clock repository.Clock) ServerOption {
 	}
 }
 
+// WithAuthorizationCodeRepository is a ServerOption that sets the repository for authorization codes.
+// By default, authorization codes are kept in memory, which only works for a single server instance.
+func WithAuthorizationCodeRepository(authorizationCodeRepository repository.AuthorizationCodeRepository) ServerOption {
+	return func(s *Server) {
+		s.authorizationCodeRepository = authorizationCodeRepository
+	}
+}
+
+// WithRefreshTokenRepository is a ServerOption that sets the repository for refresh tokens.
+// By default, refresh tokens are kept in memory, which only works for a single server instance.
+func WithRefreshTokenRepository(refreshTokenRepository repository.RefreshTokenRepository) ServerOption {
+	return func(s *Server) {
+		s.refreshTokenRepository = refreshTokenRepository
+	}
+}
+
+// WithRevocationRepository is a ServerOption that sets the repository for revoked access tokens.
+// By default, revocations are kept in memory, which only works for a single server instance.
+func WithRevocationRepository(revocationRepository repository.RevocationRepository) ServerOption {
+	return func(s *Server) {
+		s.revocationRepository = revocationRepository
+	}
+}
+
+// WithTokenRepository is a ServerOption that sets the repository for the claims of opaque access tokens.
+// By default, opaque access tokens are kept in memory, which only works for a single server instance.
+func WithTokenRepository(tokenRepository repository.TokenRepository) ServerOption {
+	return func(s *Server) {
+		s.tokenRepository = tokenRepository
+	}
+}
+
+// WithUserRepository is a ServerOption that sets the repository of the end users, whose claims are returned by the UserInfoHandler.
+func WithUserRepository(userRepository repository.UserRepository) ServerOption {
+	return func(s *Server) {
+		s.userRepository = userRepository
+	}
+}
+
+// WithAuthenticator is a ServerOption that sets the Authenticator of the end users of authorization requests.
+// Without an Authenticator, authorization requests are refused, because no end user can be authenticated.
+func WithAuthenticator(authenticator Authenticator) ServerOption {
+	return func(s *Server) {
+		s.authenticator = authenticator
+	}
+}
+
+// WithSessionRepository is a ServerOption that sets the repository for the login sessions of end users.
+// By default, sessions are kept in memory, which only works for a single server instance.
+func WithSessionRepository(sessionRepository repository.SessionRepository) ServerOption {
+	return func(s *Server) {
+		s.sessionRepository = sessionRepository
+	}
+}
+
+// WithLogin is a ServerOption that authenticates the end users of authorization requests with the session of the LoginHandler.
+// Users without session are redirected to the loginPath, under which the LoginHandler is served.
+func WithLogin(loginPath string) ServerOption {
+	return func(s *Server) {
+		s.authenticator = sessionAuthenticator{server: s, loginPath: loginPath}
+	}
+}
+
+// WithLockout is a ServerOption that locks users for the duration after maxFailedLogins failed logins in a row.
+// By default, users are locked for 15 minutes after 5 failed logins.
+func WithLockout(maxFailedLogins int, duration time.Duration) ServerOption {
+	return func(s *Server) {
+		s.maxFailedLogins = maxFailedLogins
+		s.lockoutDuration = duration
+	}
+}
+
+// WithImplicitGrant is a ServerOption that enables the implicit grant of RFC 6749 section 4.2 for legacy browser clients.
+// Even then, only clients with AllowImplicitGrant may use it. The grant is disabled by default,
+// because the access token is exposed in the URL, and the authorization code grant with PKCE should be used instead.
+func WithImplicitGrant() ServerOption {
+	return func(s *Server) {
+		s.implicitGrant = true
+	}
+}
+
+// WithPasswordGrant is a ServerOption that enables the resource owner password credentials grant of RFC 6749 section 4.3
+// for first-party clients that can not open a browser. Even then, only clients with AllowPasswordGrant may use it.
+// The grant is disabled by default, because the client handles the password of the user.
+func WithPasswordGrant() ServerOption {
+	return func(s *Server) {
+		s.passwordGrant = true
+	}
+}
+
+// ErrMissingIssuer is returned by New if the server has no issuer, which is required for the iss claim of all tokens
+// and for the metadata of RFC 8414.
+var ErrMissingIssuer = errors.New("the server has no issuer")
+
 // New creates a new IdP server with the provided client repository.
-// It generates a random signing key for token generation.
-func New(repository repository.ClientRepository, opts ...ServerOption) *Server {
-	token := []byte(randstr.String(16))
+// It generates a random ES256 signing key for token generation, unless keys are set with WithKey, WithKeySet or WithSigningKey.
+// The generated key only lives as long as the server, so production deployments should use a KeyRing.
+// An issuer must be set with WithIssuer, otherwise ErrMissingIssuer is returned. Without WithAudience,
+// the issuer is the audience of access tokens for clients without Audiences.
+func New(clientRepository repository.ClientRepository, opts ...ServerOption) (*Server, error) {
+	key, err := GenerateKey(AlgorithmES256)
+	if err != nil {
+		return nil, err
+	}
 
 	server := &Server{
-		clientRepository: &repository,
-		clock:            systemClock{},
-		signingKey:       &token,
+		clientRepository:            &clientRepository,
+		authorizationCodeRepository: repository.NewSimpleAuthorizationCodeRepository(),
+		refreshTokenRepository:      repository.NewSimpleRefreshTokenRepository(),
+		revocationRepository:        repository.NewSimpleRevocationRepository(),
+		tokenRepository:             repository.NewSimpleTokenRepository(),
+		userRepository:              repository.NewSimpleUserRepository(),
+		sessionRepository:           repository.NewSimpleSessionRepository(),
+		maxFailedLogins:             defaultMaxFailedLogins,
+		lockoutDuration:             defaultLockoutDuration,
+		clock:                       systemClock{},
+		keys:                        staticKeySet{key: key},
+		accessTokenLifetime:         defaultAccessTokenLifetime,
 	}
 
 	for _, opt := range opts {
 		opt(server)
 	}
-	return server
+	if server.issuer == "" {
+		return nil, ErrMissingIssuer
+	}
+	if server.audience == "" {
+		server.audience = server.issuer
+	}
+	return server, nil
 }
@@ -0,0 +1,222 @@
+package idp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/thanhpk/randstr"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+)
+
+const authorizationCodeLifetime = time.Minute
+
+const (
+	codeChallengeMethodPlain = "plain"
+	codeChallengeMethodS256  = "S256"
+)
+
+// codeVerifierPattern matches the code verifier and code challenge syntax of RFC 7636 section 4.1.
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// responseTypeGrantTypes maps the response types of the authorization endpoint to the grant types that use them.
+var responseTypeGrantTypes = map[string]string{
+	repository.ResponseTypeCode:  repository.GrantTypeAuthorizationCode,
+	repository.ResponseTypeToken: repository.GrantTypeImplicit,
+}
+
+// AuthorizeHandler handles authorization requests of the authorization code grant.
+// It validates the client and its redirect URI and redirects back to the client with a short-lived, single-use code.
+//
+// If the client is unknown or disabled, or the redirect URI is not registered for the client, it responds with a 400 Bad Request status
+// and an invalid_request error, because the error can not safely be returned to the redirect URI.
+// All other errors are returned to the redirect URI of the client as described in RFC 6749 section 4.1.2.1.
+// The authorization request must contain a PKCE code challenge as described in RFC 7636.
+// The end user is authenticated with the Authenticator of the server, which may take over the response to log the user in.
+// Without an Authenticator, it responds with a 500 Internal Server Error status and a server_error error.
+// The nonce of OpenID Connect is stored with the code, so that it is returned in the ID token.
+// If the implicit grant is enabled, response_type=token returns the access token in the fragment of the redirect URI instead.
+// Clients with ResponseTypes may only use those response types, and clients with GrantTypes only the response type
+// of an allowed grant type, otherwise an unauthorized_client error is returned.
+func (s *Server) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	clientId := r.FormValue("client_id")
+	client, err := (*s.clientRepository).GetClient(clientId)
+	if err != nil || clientId == "" {
+		writeError(w, r, NewError(ErrorInvalidRequest, "Unknown client"))
+		return
+	}
+	if client.Disabled {
+		writeError(w, r, NewError(ErrorInvalidRequest, "The client is disabled"))
+		return
+	}
+
+	requestedRedirectUri := r.FormValue("redirect_uri")
+	redirectUri, ok := resolveRedirectUri(client, requestedRedirectUri)
+	if !ok {
+		writeError(w, r, NewError(ErrorInvalidRequest, "Invalid redirect URI"))
+		return
+	}
+
+	state := r.FormValue("state")
+	responseType := r.FormValue("response_type")
+	if grantType, ok := responseTypeGrantTypes[responseType]; ok && (!client.AllowsResponseType(responseType) || !client.AllowsGrantType(grantType)) {
+		err := NewError(ErrorUnauthorizedClient, "The client is not allowed to use the response type")
+		if responseType == repository.ResponseTypeToken {
+			redirectWithFragment(w, r, redirectUri, errorParameters(state, err))
+			return
+		}
+		redirectWithError(w, r, redirectUri, state, err)
+		return
+	}
+	if responseType == repository.ResponseTypeToken && s.implicitGrant {
+		s.authorizeImplicit(w, r, client, redirectUri, state)
+		return
+	}
+	if responseType != repository.ResponseTypeCode {
+		redirectWithError(w, r, redirectUri, state, NewError(ErrorUnsupportedResponseType, "Only the response type code is supported"))
+		return
+	}
+
+	codeChallenge := r.FormValue("code_challenge")
+	codeChallengeMethod := r.FormValue("code_challenge_method")
+	if codeChallengeMethod == "" {
+		codeChallengeMethod = codeChallengeMethodPlain
+	}
+	if !codeVerifierPattern.MatchString(codeChallenge) {
+		redirectWithError(w, r, redirectUri, state, NewError(ErrorInvalidRequest, "A valid code_challenge is required"))
+		return
+	}
+	if codeChallengeMethod != codeChallengeMethodPlain && codeChallengeMethod != codeChallengeMethodS256 {
+		redirectWithError(w, r, redirectUri, state, NewError(ErrorInvalidRequest, "Unsupported code_challenge_method"))
+		return
+	}
+
+	scope, err := grantScope(r.FormValue("scope"), client.Scopes)
+	if err != nil {
+		redirectWithError(w, r, redirectUri, state, err.(*Error))
+		return
+	}
+
+	audiences, err := grantAudience(r.Form["resource"], client.Audiences)
+	if err != nil {
+		redirectWithError(w, r, redirectUri, state, err.(*Error))
+		return
+	}
+
+	authentication, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	code := repository.AuthorizationCode{
+		Code:                randstr.String(32),
+		ClientId:            client.ClientId,
+		Subject:             authentication.Subject,
+		RedirectUri:         requestedRedirectUri,
+		Scope:               scope,
+		Audiences:           audiences,
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: codeChallengeMethod,
+		Nonce:               r.FormValue("nonce"),
+		AuthTime:            authentication.AuthTime,
+		Acr:                 authentication.Acr,
+		ExpiresAt:           s.clock.Now().Add(authorizationCodeLifetime),
+	}
+	if err := s.authorizationCodeRepository.SaveAuthorizationCode(code); err != nil {
+		redirectWithError(w, r, redirectUri, state, NewError(ErrorServerError, "The authorization code could not be stored"))
+		return
+	}
+
+	query := url.Values{"code": {code.Code}}
+	if state != "" {
+		query.Set("state", state)
+	}
+	redirect(w, r, redirectUri, query)
+}
+
+// redeemAuthorizationCode consumes the code of the token request and verifies that it was issued to the client
+// for the same redirect URI, and that the code verifier matches the code challenge of the authorization request.
+func (s *Server) redeemAuthorizationCode(request tokenRequest) (*repository.AuthorizationCode, bool) {
+	if request.Code == "" {
+		return nil, false
+	}
+
+	code, err := s.authorizationCodeRepository.ConsumeAuthorizationCode(request.Code)
+	if err != nil {
+		return nil, false
+	}
+
+	if !s.clock.Now().Before(code.ExpiresAt) {
+		return nil, false
+	}
+
+	if code.ClientId != request.ClientId || code.RedirectUri != request.RedirectUri {
+		return nil, false
+	}
+
+	if !verifyCodeChallenge(code.CodeChallenge, code.CodeChallengeMethod, request.CodeVerifier) {
+		return nil, false
+	}
+
+	return code, true
+}
+
+func verifyCodeChallenge(challenge string, method string, verifier string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+
+	expected := verifier
+	if method == codeChallengeMethodS256 {
+		hash := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// resolveRedirectUri returns the redirect URI to use for the client.
+// A requested redirect URI must exactly match one of the registered URIs.
+// If none is requested, the client must have exactly one registered redirect URI.
+func resolveRedirectUri(client *repository.Client, requested string) (string, bool) {
+	if requested == "" {
+		if len(client.RedirectUris) != 1 {
+			return "", false
+		}
+		return client.RedirectUris[0], true
+	}
+
+	if !slices.Contains(client.RedirectUris, requested) {
+		return "", false
+	}
+	return requested, true
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, redirectUri string, state string, err *Error) {
+	redirect(w, r, redirectUri, errorParameters(state, err))
+}
+
+func errorParameters(state string, err *Error) url.Values {
+	parameters := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		parameters.Set("error_description", err.Description)
+	}
+	if state != "" {
+		parameters.Set("state", state)
+	}
+	return parameters
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, redirectUri string, query url.Values) {
+	target, _ := url.Parse(redirectUri)
+	values := target.Query()
+	for key, value := range query {
+		values[key] = value
+	}
+	target.RawQuery = values.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
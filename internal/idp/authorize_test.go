@@ -0,0 +1,138 @@
+package idp_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+const codeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func codeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func authorize(api http.Handler, query url.Values) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil)
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func authorizationQuery() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"1234567890"},
+		"redirect_uri":          {"http://localhost:3000/callback"},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RedirectsWithCode() {
+	// when sending a valid authorization request
+	response := authorize(suite.InitIdpApi(), authorizationQuery())
+
+	// then the client is redirected with a code and the state
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "localhost:3000", location.Host)
+	assert.Equal(suite.T(), "/callback", location.Path)
+	assert.Len(suite.T(), location.Query().Get("code"), 32)
+	assert.Equal(suite.T(), "xyz", location.Query().Get("state"))
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_ReturnsBadRequestIfRedirectUriNotRegistered() {
+	// when sending an authorization request with an unregistered redirect URI
+	query := authorizationQuery()
+	query.Set("redirect_uri", "https://attacker.example/callback")
+	response := authorize(suite.InitIdpApi(), query)
+
+	// then the client is not redirected
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_request\",\"error_description\":\"Invalid redirect URI\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RedirectsWithErrorIfCodeChallengeMissing() {
+	// when sending an authorization request without a code challenge
+	query := authorizationQuery()
+	query.Del("code_challenge")
+	response := authorize(suite.InitIdpApi(), query)
+
+	// then the client is redirected with an error
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "invalid_request", location.Query().Get("error"))
+	assert.Equal(suite.T(), "xyz", location.Query().Get("state"))
+	assert.Empty(suite.T(), location.Query().Get("code"))
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_ExchangesAuthorizationCode() {
+	// given an authorization code
+	api := suite.InitIdpApi()
+	location, _ := url.Parse(authorize(api, authorizationQuery()).Header().Get("Location"))
+	code := location.Query().Get("code")
+
+	// when exchanging the code with the code verifier
+	response := exchangeCode(api, code, codeVerifier)
+
+	// then the response should be 200 OK with an access token
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	var jsonTokenResponse tokenResponse
+	err := json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), jsonTokenResponse.AccessToken)
+	assert.Equal(suite.T(), "Bearer", jsonTokenResponse.TokenType)
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_ReturnsBadRequestIfCodeVerifierWrong() {
+	// given an authorization code
+	api := suite.InitIdpApi()
+	location, _ := url.Parse(authorize(api, authorizationQuery()).Header().Get("Location"))
+	code := location.Query().Get("code")
+
+	// when exchanging the code with a wrong code verifier
+	response := exchangeCode(api, code, "wrong-verifier-wrong-verifier-wrong-verifier")
+
+	// then the response should be 400 Bad Request
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_grant\",\"error_description\":\"Invalid authorization code\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_ReturnsBadRequestIfCodeIsReused() {
+	// given an authorization code that was already exchanged
+	api := suite.InitIdpApi()
+	location, _ := url.Parse(authorize(api, authorizationQuery()).Header().Get("Location"))
+	code := location.Query().Get("code")
+	exchangeCode(api, code, codeVerifier)
+
+	// when exchanging the code a second time
+	response := exchangeCode(api, code, codeVerifier)
+
+	// then the response should be 400 Bad Request
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+}
+
+func exchangeCode(api http.Handler, code string, verifier string) *httptest.ResponseRecorder {
+	requestBody, _ := json.Marshal(map[string]string{
+		"grant_type":    "authorization_code",
+		"client_id":     "1234567890",
+		"client_secret": "client_secret",
+		"code":          code,
+		"redirect_uri":  "http://localhost:3000/callback",
+		"code_verifier": verifier,
+	})
+	request := httptest.NewRequest(http.MethodPost, "/token", bytes.NewBuffer(requestBody))
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
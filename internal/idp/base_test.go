@@ -1,11 +1,15 @@
 package idp_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"github.com/daschaa/open-idp/internal/idp"
 	"github.com/daschaa/open-idp/internal/repository"
 	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -29,14 +33,62 @@ func (suite *serverSuite) SetupTest() {
 	suite.signingKey = []byte("your_secret_key")
 }
 
+// server creates a server with the client repository, clock and signing key of the suite,
+// which authenticates the end users of authorization requests with the testAuthenticator.
+func (suite *serverSuite) server() *idp.Server {
+	return suite.newServer(idp.WithSigningKey(&suite.signingKey), idp.WithClock(suite.clock), idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithAuthenticator(testAuthenticator{}))
+}
+
+// newServer creates a server with the client repository of the suite and the options.
+func (suite *serverSuite) newServer(opts ...idp.ServerOption) *idp.Server {
+	server, err := idp.New(suite.clientRepository, opts...)
+	suite.Require().NoError(err)
+	return server
+}
+
 func (suite *serverSuite) InitIdpApi() http.Handler {
 	router := mux.NewRouter()
-	server := idp.New(suite.clientRepository, idp.WithSigningKey(&suite.signingKey), idp.WithClock(suite.clock))
+	server := suite.server()
+	router.HandleFunc("/authorize", server.AuthorizeHandler)
 	router.HandleFunc("/token", server.TokenHandler)
 	router.HandleFunc("/introspect", server.IntrospectHandler)
+	router.HandleFunc("/revoke", server.RevokeHandler)
+	router.HandleFunc("/userinfo", server.UserInfoHandler)
+	router.HandleFunc("/.well-known/jwks.json", server.JwksHandler)
+	router.HandleFunc("/.well-known/oauth-authorization-server", server.MetadataHandler)
+	router.HandleFunc("/.well-known/openid-configuration", server.OpenIdConfigurationHandler)
 	return router
 }
 
+func issueToken(suite *serverSuite, server *idp.Server) string {
+	requestBody := `{"client_id":"1234567890","client_secret":"client_secret","grant_type":"client_credentials"}`
+	response := httptest.NewRecorder()
+	server.TokenHandler(response, httptest.NewRequest(http.MethodPost, "/token", bytes.NewBufferString(requestBody)))
+	var jsonTokenResponse tokenResponse
+	err := json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+	assert.NoError(suite.T(), err)
+	return jsonTokenResponse.AccessToken
+}
+
+func introspect(suite *serverSuite, server *idp.Server, token string) map[string]interface{} {
+	request := httptest.NewRequest(http.MethodPost, "/introspect", bytes.NewBufferString(`{"token":"`+token+`"}`))
+	request.SetBasicAuth("1234567890", "client_secret")
+	response := httptest.NewRecorder()
+	server.IntrospectHandler(response, request)
+	introspection := map[string]interface{}{}
+	err := json.NewDecoder(response.Body).Decode(&introspection)
+	assert.NoError(suite.T(), err)
+	return introspection
+}
+
+// signToken signs the claims as access token with the signing key of the suite.
+func (suite *serverSuite) signToken(claims map[string]interface{}) string {
+	signingString := encodeSegment(map[string]interface{}{"alg": "HS256", "kid": "default", "typ": "at+jwt"}) + "." + encodeSegment(claims)
+	signature, err := idp.NewHmacKey("default", suite.signingKey).Sign(signingString)
+	assert.NoError(suite.T(), err)
+	return signingString + "." + signature
+}
+
 func TestServerSuite(t *testing.T) {
 	suite.Run(t, new(serverSuite))
 }
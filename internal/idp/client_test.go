@@ -0,0 +1,255 @@
+package idp_test
+
+import (
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsGrantTypeNotRegisteredForClient() {
+	// given a client that is restricted to the authorization code grant
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:      "webapp",
+		ClientSecret:  "webapp_secret",
+		RedirectUris:  []string{"http://localhost:3000/callback"},
+		GrantTypes:    []string{repository.GrantTypeAuthorizationCode, repository.GrantTypeRefreshToken},
+		ResponseTypes: []string{repository.ResponseTypeCode},
+	})
+	assert.NoError(suite.T(), err)
+
+	// when the client uses the client credentials grant
+	response := clientCredentialsGrant(suite.InitIdpApi(), "webapp", "webapp_secret")
+
+	// then the response should be 400 Bad Request with an unauthorized_client error
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "unauthorized_client")
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RejectsResponseTypeNotRegisteredForClient() {
+	// given a client that is restricted to the client credentials grant and the response type token
+	_, err := suite.clientRepository.UpdateClient(repository.Client{
+		ClientId:           "1234567890",
+		RedirectUris:       []string{"http://localhost:3000/callback"},
+		GrantTypes:         []string{repository.GrantTypeClientCredentials, repository.GrantTypeImplicit},
+		ResponseTypes:      []string{repository.ResponseTypeToken},
+		AllowImplicitGrant: true,
+	})
+	assert.NoError(suite.T(), err)
+
+	// when sending an authorization request with the response type code
+	response := authorize(suite.InitIdpApi(), authorizationQuery())
+
+	// then the client is redirected with an unauthorized_client error
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "unauthorized_client", location.Query().Get("error"))
+	assert.Empty(suite.T(), location.Query().Get("code"))
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RejectsCodeOfClientWithoutAuthorizationCodeGrant() {
+	// given a client that is restricted to the client credentials grant, but has no response type restriction
+	_, err := suite.clientRepository.UpdateClient(repository.Client{
+		ClientId:     "1234567890",
+		RedirectUris: []string{"http://localhost:3000/callback"},
+		GrantTypes:   []string{repository.GrantTypeClientCredentials},
+	})
+	assert.NoError(suite.T(), err)
+
+	// when sending an authorization request with the response type code
+	response := authorize(suite.InitIdpApi(), authorizationQuery())
+
+	// then the client is redirected with an unauthorized_client error instead of a code
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "unauthorized_client", location.Query().Get("error"))
+	assert.Empty(suite.T(), location.Query().Get("code"))
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RejectsTokenOfClientWithoutImplicitGrant() {
+	// given a client that is allowed to use the implicit grant, but is restricted to the authorization code grant
+	api := suite.implicitApi(idp.WithImplicitGrant())
+	_, err := suite.clientRepository.UpdateClient(repository.Client{
+		ClientId:           "spa",
+		RedirectUris:       []string{"http://localhost:3000/spa"},
+		Scopes:             []string{"read:example"},
+		GrantTypes:         []string{repository.GrantTypeAuthorizationCode},
+		AllowImplicitGrant: true,
+	})
+	assert.NoError(suite.T(), err)
+
+	// when sending an authorization request with the response type token
+	response := authorize(api, implicitQuery("spa", "http://localhost:3000/spa"))
+
+	// then the client is redirected with an unauthorized_client error in the fragment instead of an access token
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	fragment, err := url.ParseQuery(location.Fragment)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "unauthorized_client", fragment.Get("error"))
+	assert.False(suite.T(), fragment.Has("access_token"))
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RejectsResponseTypeTokenInFragment() {
+	// given a client that is allowed to use the implicit grant, but is restricted to the response type code
+	api := suite.implicitApi(idp.WithImplicitGrant())
+	_, err := suite.clientRepository.UpdateClient(repository.Client{
+		ClientId:           "spa",
+		RedirectUris:       []string{"http://localhost:3000/spa"},
+		Scopes:             []string{"read:example"},
+		ResponseTypes:      []string{repository.ResponseTypeCode},
+		AllowImplicitGrant: true,
+	})
+	assert.NoError(suite.T(), err)
+
+	// when sending an authorization request with the response type token
+	response := authorize(api, implicitQuery("spa", "http://localhost:3000/spa"))
+
+	// then the client is redirected with an unauthorized_client error in the fragment
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), location.RawQuery)
+	fragment, err := url.ParseQuery(location.Fragment)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "unauthorized_client", fragment.Get("error"))
+	assert.Equal(suite.T(), "xyz", fragment.Get("state"))
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_UsesIdTokenLifetimeOfClient() {
+	// given a client with its own ID token lifetime
+	api := suite.oidcApi()
+	_, err := suite.clientRepository.UpdateClient(repository.Client{
+		ClientId:        "webapp",
+		RedirectUris:    []string{"http://localhost:3000/callback"},
+		Scopes:          []string{"openid", "profile", "email", "read:example"},
+		IdTokenLifetime: 5 * time.Minute,
+	})
+	assert.NoError(suite.T(), err)
+
+	// when signing in with the openid scope
+	tokenResponse := suite.signIn(api, "openid")
+
+	// then the ID token expires after the lifetime of the client
+	claims := decodeSegment(suite, tokenResponse.IdToken, 1)
+	assert.Equal(suite.T(), float64(suite.clock.Now().Add(5*time.Minute).Unix()), claims["exp"])
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_UsesRefreshTokenLifetimeOfClient() {
+	// given a client whose refresh tokens expire after a minute
+	_, err := suite.clientRepository.UpdateClient(repository.Client{
+		ClientId:             "1234567890",
+		RedirectUris:         []string{"http://localhost:3000/callback"},
+		Scopes:               []string{"read:example"},
+		AllowIntrospection:   true,
+		RefreshTokenLifetime: time.Minute,
+	})
+	assert.NoError(suite.T(), err)
+	clock := &mutableClock{now: suite.clock.Now()}
+	suite.clock = clock
+	api := suite.InitIdpApi()
+	refreshToken := suite.obtainRefreshToken(api)
+
+	// when the refresh token is used after it expired
+	clock.now = clock.now.Add(time.Minute)
+	response := refresh(api, refreshToken)
+
+	// then the response should be 400 Bad Request with an invalid_grant error
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	var errorResponse map[string]string
+	err = json.NewDecoder(response.Body).Decode(&errorResponse)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "invalid_grant", errorResponse["error"])
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsClientCredentialsGrantOfPublicClient() {
+	// given a public client without secrets and without grant type restrictions
+	_, err := suite.clientRepository.SaveClient(repository.Client{ClientId: "spa", RedirectUris: []string{"http://localhost:3000/callback"}, TokenEndpointAuthMethod: repository.AuthMethodNone})
+	assert.NoError(suite.T(), err)
+
+	// when the client uses the client credentials grant without secret
+	response := clientCredentialsGrant(suite.InitIdpApi(), "spa", "")
+
+	// then the response should be 400 Bad Request with an unauthorized_client error
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "unauthorized_client")
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_EnforcesTokenEndpointAuthMethod() {
+	// given a client that registered client_secret_post
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:                "backend",
+		ClientSecret:            "backend_secret",
+		TokenEndpointAuthMethod: repository.AuthMethodClientSecretPost,
+	})
+	assert.NoError(suite.T(), err)
+	api := suite.InitIdpApi()
+
+	// when the client authenticates with client_secret_basic
+	basic := clientCredentialsGrant(api, "backend", "backend_secret")
+
+	// then the response should be 401 Unauthorized with an invalid_client error
+	assert.Equal(suite.T(), http.StatusUnauthorized, basic.Result().StatusCode)
+	assert.Contains(suite.T(), basic.Body.String(), "invalid_client")
+
+	// and a token is issued with client_secret_post
+	requestBody := url.Values{"grant_type": {"client_credentials"}, "client_id": {"backend"}, "client_secret": {"backend_secret"}}
+	request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(requestBody.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	post := httptest.NewRecorder()
+	api.ServeHTTP(post, request)
+	assert.Equal(suite.T(), http.StatusOK, post.Result().StatusCode)
+}
+
+func (suite *serverSuite) Test_IntrospectEndpoint_RejectsPublicClient() {
+	// given a public client, which is allowed to introspect tokens, and one of its access tokens
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:                "spa",
+		RedirectUris:            []string{"http://localhost:3000/callback"},
+		TokenEndpointAuthMethod: repository.AuthMethodNone,
+		AllowIntrospection:      true,
+	})
+	assert.NoError(suite.T(), err)
+	api := suite.InitIdpApi()
+	query := authorizationQuery()
+	query.Set("client_id", "spa")
+	location, _ := url.Parse(authorize(api, query).Header().Get("Location"))
+	requestBody := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"spa"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {"http://localhost:3000/callback"},
+		"code_verifier": {codeVerifier},
+	}
+	request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(requestBody.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	var token tokenResponse
+	err = json.NewDecoder(response.Body).Decode(&token)
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), token.AccessToken)
+
+	// when the client introspects the token with its client id only, and with the token as bearer token
+	byClientId := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(url.Values{"token": {token.AccessToken}, "client_id": {"spa"}}.Encode()))
+	byClientId.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	byBearer := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(url.Values{"token": {token.AccessToken}}.Encode()))
+	byBearer.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	byBearer.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	for _, request := range []*http.Request{byClientId, byBearer} {
+		response := httptest.NewRecorder()
+		api.ServeHTTP(response, request)
+
+		// then the response should be 401 Unauthorized with an invalid_client error
+		assert.Equal(suite.T(), http.StatusUnauthorized, response.Result().StatusCode)
+		assert.Contains(suite.T(), response.Body.String(), "invalid_client")
+	}
+}
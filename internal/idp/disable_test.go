@@ -0,0 +1,50 @@
+package idp_test
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+)
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsDisabledClient() {
+	// given a disabled client
+	err := suite.clientRepository.DisableClient("1234567890", "compromised")
+	assert.NoError(suite.T(), err)
+
+	// when the client requests a token with its secret
+	response := clientCredentialsGrant(suite.InitIdpApi(), "1234567890", "client_secret")
+
+	// then the response should be 401 Unauthorized with an invalid_client error
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_client\",\"error_description\":\"Client authentication failed\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_IntrospectEndpoint_ReturnsIsNotActiveForTokenOfDisabledClient() {
+	// given a token of a client, which is disabled afterwards
+	_, err := suite.clientRepository.SaveClient(repository.Client{ClientId: "backend", ClientSecret: "backend_secret"})
+	assert.NoError(suite.T(), err)
+	token := suite.accessToken(clientCredentialsGrant(suite.InitIdpApi(), "backend", "backend_secret"))
+	server := suite.server()
+	assert.Equal(suite.T(), true, introspect(suite, server, token)["active"])
+	err = suite.clientRepository.DisableClient("backend", "compromised")
+	assert.NoError(suite.T(), err)
+
+	// when introspecting the token
+	introspection := introspect(suite, server, token)
+
+	// then the token is inactive
+	assert.Equal(suite.T(), map[string]interface{}{"active": false}, introspection)
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RejectsDisabledClient() {
+	// given a disabled client
+	err := suite.clientRepository.DisableClient("1234567890", "compromised")
+	assert.NoError(suite.T(), err)
+
+	// when sending an authorization request of the client
+	response := authorize(suite.InitIdpApi(), authorizationQuery())
+
+	// then the response should be 400 Bad Request without redirect
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Empty(suite.T(), response.Header().Get("Location"))
+}
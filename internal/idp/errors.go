@@ -0,0 +1,86 @@
+package idp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error codes of RFC 6749 section 4.1.2.1 and section 5.2.
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+	ErrorServerError             = "server_error"
+	// ErrorInvalidTarget is defined by RFC 8707 section 2 for resource indicators.
+	ErrorInvalidTarget = "invalid_target"
+	// ErrorInvalidToken and ErrorInsufficientScope are defined by RFC 6750 section 3.1 for requests with an access token.
+	ErrorInvalidToken      = "invalid_token"
+	ErrorInsufficientScope = "insufficient_scope"
+)
+
+// Error is an OAuth 2.0 error response as described in RFC 6749 section 5.2.
+// All handlers of the server report failures with this type, so that clients can parse them.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// Status returns the HTTP status code for the error.
+// invalid_client and invalid_token are answered with 401 Unauthorized, insufficient_scope with 403 Forbidden,
+// server_error with 500 Internal Server Error and all other errors with 400 Bad Request.
+func (e *Error) Status() int {
+	switch e.Code {
+	case ErrorInvalidClient, ErrorInvalidToken:
+		return http.StatusUnauthorized
+	case ErrorInsufficientScope:
+		return http.StatusForbidden
+	case ErrorServerError:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// NewError creates an OAuth 2.0 error with the given error code and a human-readable description.
+func NewError(code string, description string) *Error {
+	return &Error{
+		Code:        code,
+		Description: description,
+	}
+}
+
+// writeError writes the error as JSON body.
+// Errors that are not an *Error are reported as server_error without exposing their message.
+// If a client failed to authenticate with HTTP Basic authentication, the WWW-Authenticate header is set as required by RFC 6749 section 5.2.
+// Errors of requests with an access token set the Bearer WWW-Authenticate header of RFC 6750 section 3.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var oauthError *Error
+	if !errors.As(err, &oauthError) {
+		oauthError = NewError(ErrorServerError, "")
+	}
+
+	if _, _, ok := r.BasicAuth(); ok && oauthError.Code == ErrorInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="open-idp"`)
+	}
+	if oauthError.Code == ErrorInvalidToken || oauthError.Code == ErrorInsufficientScope {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="open-idp", error=%q`, oauthError.Code))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(oauthError.Status())
+	_ = json.NewEncoder(w).Encode(oauthError)
+}
@@ -0,0 +1,63 @@
+package idp
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// authorizeImplicit handles an authorization request of the implicit grant as described in RFC 6749 section 4.2.
+// The access token and all errors are returned in the fragment of the redirect URI, so that they are not sent to the
+// server of the client. No refresh token is issued.
+func (s *Server) authorizeImplicit(w http.ResponseWriter, r *http.Request, client *repository.Client, redirectUri string, state string) {
+	if !client.AllowImplicitGrant {
+		redirectWithFragment(w, r, redirectUri, errorParameters(state, NewError(ErrorUnauthorizedClient, "The client is not allowed to use the implicit grant")))
+		return
+	}
+
+	scope, err := grantScope(r.FormValue("scope"), client.Scopes)
+	if err != nil {
+		redirectWithFragment(w, r, redirectUri, errorParameters(state, err.(*Error)))
+		return
+	}
+
+	audiences, err := grantAudience(r.Form["resource"], client.Audiences)
+	if err != nil {
+		redirectWithFragment(w, r, redirectUri, errorParameters(state, err.(*Error)))
+		return
+	}
+
+	authentication, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	lifetime := s.clientAccessTokenLifetime(client)
+	token, err := s.issueAccessToken(client, authentication.Subject, scope, audiences, lifetime)
+	if err != nil {
+		redirectWithFragment(w, r, redirectUri, errorParameters(state, NewError(ErrorServerError, "The access token could not be issued")))
+		return
+	}
+
+	fragment := url.Values{
+		"access_token": {token},
+		"token_type":   {"Bearer"},
+		"expires_in":   {strconv.FormatInt(int64(lifetime/time.Second), 10)},
+	}
+	if scope != "" {
+		fragment.Set("scope", scope)
+	}
+	if state != "" {
+		fragment.Set("state", state)
+	}
+	redirectWithFragment(w, r, redirectUri, fragment)
+}
+
+func redirectWithFragment(w http.ResponseWriter, r *http.Request, redirectUri string, fragment url.Values) {
+	target, _ := url.Parse(redirectUri)
+	target.Fragment = ""
+	target.RawFragment = ""
+	http.Redirect(w, r, target.String()+"#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,124 @@
+package idp_test
+
+import (
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+// implicitApi returns the API of a server, which enables the implicit grant for the client spa.
+func (suite *serverSuite) implicitApi(opts ...idp.ServerOption) http.Handler {
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:           "spa",
+		RedirectUris:       []string{"http://localhost:3000/spa"},
+		Scopes:             []string{"read:example"},
+		AllowImplicitGrant: true,
+	})
+	assert.NoError(suite.T(), err)
+
+	opts = append([]idp.ServerOption{
+		idp.WithSigningKey(&suite.signingKey),
+		idp.WithClock(suite.clock),
+		idp.WithIssuer("https://idp.example.com"),
+		idp.WithAudience("https://api.example.com"),
+		idp.WithAuthenticator(testAuthenticator{}),
+	}, opts...)
+	server := suite.newServer(opts...)
+	router := mux.NewRouter()
+	router.HandleFunc("/authorize", server.AuthorizeHandler)
+	router.HandleFunc("/.well-known/oauth-authorization-server", server.MetadataHandler)
+	return router
+}
+
+func implicitQuery(clientId string, redirectUri string) url.Values {
+	return url.Values{
+		"response_type": {"token"},
+		"client_id":     {clientId},
+		"redirect_uri":  {redirectUri},
+		"state":         {"xyz"},
+	}
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_ReturnsAccessTokenInFragment() {
+	// when requesting a token with the implicit grant
+	response := authorize(suite.implicitApi(idp.WithImplicitGrant()), implicitQuery("spa", "http://localhost:3000/spa"))
+
+	// then the access token is returned in the fragment of the redirect URI
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "/spa", location.Path)
+	assert.Empty(suite.T(), location.RawQuery)
+	fragment, err := url.ParseQuery(location.Fragment)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "Bearer", fragment.Get("token_type"))
+	assert.Equal(suite.T(), "3600", fragment.Get("expires_in"))
+	assert.Equal(suite.T(), "read:example", fragment.Get("scope"))
+	assert.Equal(suite.T(), "xyz", fragment.Get("state"))
+	assert.False(suite.T(), fragment.Has("refresh_token"))
+	claims := decodeSegment(suite, fragment.Get("access_token"), 1)
+	assert.Equal(suite.T(), "alice", claims["sub"])
+	assert.Equal(suite.T(), "spa", claims["client_id"])
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RejectsImplicitGrantByDefault() {
+	// when requesting a token with the implicit grant from a server, which has not enabled it
+	response := authorize(suite.implicitApi(), implicitQuery("spa", "http://localhost:3000/spa"))
+
+	// then the client is redirected with an unsupported_response_type error
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "unsupported_response_type", location.Query().Get("error"))
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RejectsImplicitGrantOfOtherClients() {
+	// when a client, which is not allowed to use the implicit grant, requests a token
+	response := authorize(suite.implicitApi(idp.WithImplicitGrant()), implicitQuery("1234567890", "http://localhost:3000/callback"))
+
+	// then the client is redirected with an unauthorized_client error in the fragment
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	fragment, err := url.ParseQuery(location.Fragment)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "unauthorized_client", fragment.Get("error"))
+	assert.Equal(suite.T(), "xyz", fragment.Get("state"))
+	assert.False(suite.T(), fragment.Has("access_token"))
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RequiresExactRedirectUriForImplicitGrant() {
+	// when requesting a token for a redirect URI, which only starts with the registered redirect URI
+	response := authorize(suite.implicitApi(idp.WithImplicitGrant()), implicitQuery("spa", "http://localhost:3000/spa/other"))
+
+	// then the response should be 400 Bad Request without redirect
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Empty(suite.T(), response.Header().Get("Location"))
+}
+
+func (suite *serverSuite) Test_MetadataEndpoint_AdvertisesImplicitGrantIfEnabled() {
+	for _, enabled := range []bool{false, true} {
+		// given a server with or without the implicit grant
+		var opts []idp.ServerOption
+		if enabled {
+			opts = append(opts, idp.WithImplicitGrant())
+		}
+
+		// when requesting the metadata
+		response := httptest.NewRecorder()
+		suite.implicitApi(opts...).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/.well-known/oauth-authorization-server", nil))
+		var metadata map[string]interface{}
+		err := json.NewDecoder(response.Body).Decode(&metadata)
+		assert.NoError(suite.T(), err)
+
+		// then the implicit grant and the token response type are only advertised if enabled
+		assert.Equal(suite.T(), enabled, assert.ObjectsAreEqual([]interface{}{"code", "token"}, metadata["response_types_supported"]))
+		assert.Equal(suite.T(), enabled, assert.ObjectsAreEqual(
+			[]interface{}{"authorization_code", "client_credentials", "refresh_token", "implicit"}, metadata["grant_types_supported"]))
+	}
+}
@@ -0,0 +1,32 @@
+package idp
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JwksHandler publishes the public keys of the server as JSON Web Key Set as described in RFC 7517.
+// Resource servers use it to verify the signature of access tokens locally, without calling the IntrospectHandler.
+// Retired keys are published until their grace period ends, so that tokens signed before a key rotation stay verifiable.
+// Symmetric keys are never published, so the key set is empty if the server signs with an HS256 key.
+func (s *Server) JwksHandler(w http.ResponseWriter, r *http.Request) {
+	verificationKeys, err := s.keys.VerificationKeys()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	keys := []*Jwk{}
+	for _, key := range verificationKeys {
+		if jwk := key.Jwk(); jwk != nil {
+			keys = append(keys, jwk)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,346 @@
+package idp
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"github.com/daschaa/open-idp/internal/repository"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// minimumKeyReloadInterval limits how often tokens with an unknown key id cause the keys to be reloaded.
+const minimumKeyReloadInterval = 10 * time.Second
+
+// KeySet provides the keys of the server.
+type KeySet interface {
+	// SigningKey returns the key that signs new tokens.
+	SigningKey() (Key, error)
+	// VerificationKey returns the key with the given key id, if tokens signed with it are still accepted.
+	VerificationKey(kid string) (Key, bool)
+	// VerificationKeys returns all keys, which tokens signed with are still accepted.
+	VerificationKeys() ([]Key, error)
+}
+
+type staticKeySet struct {
+	key Key
+}
+
+func (s staticKeySet) SigningKey() (Key, error) {
+	return s.key, nil
+}
+
+func (s staticKeySet) VerificationKey(kid string) (Key, bool) {
+	if kid == "" || kid != s.key.Id() {
+		return nil, false
+	}
+	return s.key, true
+}
+
+func (s staticKeySet) VerificationKeys() ([]Key, error) {
+	return []Key{s.key}, nil
+}
+
+// KeyRingConfig configures the rotation of a KeyRing. Zero values are replaced by their defaults.
+type KeyRingConfig struct {
+	// Algorithm of the generated keys, ES256 by default.
+	Algorithm string
+	// RotationInterval after which the active key is replaced by a new key, 24 hours by default.
+	RotationInterval time.Duration
+	// GracePeriod during which a retired key is still used to verify tokens, 24 hours by default.
+	// A shorter grace period than MaxTokenLifetime is raised to it, so that no token outlives its key.
+	GracePeriod time.Duration
+	// MaxTokenLifetime is the longest lifetime of the tokens signed with the keys, the default lifetime
+	// of access tokens and ID tokens by default. It must cover the token lifetimes of all clients.
+	MaxTokenLifetime time.Duration
+	// RefreshInterval after which the keys are reloaded from the repository,
+	// so that keys rotated by other server instances are picked up. One minute by default.
+	RefreshInterval time.Duration
+	// Clock used to decide when keys are rotated and expire, the system clock by default.
+	Clock repository.Clock
+	// KmsClient and KmsKeyId of a symmetric KMS key, with which the material of the keys is envelope encrypted
+	// before it is stored. Unencrypted keys in the repository are then ignored, so that nobody who can write
+	// to the repository can plant a signing key. Without a KMS key, the material is stored unencrypted,
+	// which is only suited for tests and local development.
+	KmsClient KmsClient
+	KmsKeyId  string
+}
+
+// KeyRing is a KeySet with one active signing key and retired keys, which are still used for verification
+// until their grace period ends. The keys are stored in a repository, so that all server instances share them,
+// and are envelope encrypted with KMS if the KeyRingConfig has a KMS key.
+//
+// The active key is rotated when it is older than the rotation interval. Rotation happens lazily when a token is signed,
+// so the key ring does not need a background process.
+type KeyRing struct {
+	repository repository.KeyRepository
+	config     KeyRingConfig
+	mu         sync.Mutex
+	keys       []ringKey
+	loadedAt   time.Time
+}
+
+type ringKey struct {
+	key    Key
+	record repository.SigningKey
+}
+
+// NewKeyRing creates a key ring, which stores its keys in the repository.
+func NewKeyRing(repository repository.KeyRepository, config KeyRingConfig) *KeyRing {
+	if config.Algorithm == "" {
+		config.Algorithm = AlgorithmES256
+	}
+	if config.RotationInterval == 0 {
+		config.RotationInterval = 24 * time.Hour
+	}
+	if config.GracePeriod == 0 {
+		config.GracePeriod = 24 * time.Hour
+	}
+	if config.MaxTokenLifetime == 0 {
+		config.MaxTokenLifetime = max(defaultAccessTokenLifetime, idTokenLifetime)
+	}
+	if config.GracePeriod < config.MaxTokenLifetime {
+		config.GracePeriod = config.MaxTokenLifetime
+	}
+	if config.RefreshInterval == 0 {
+		config.RefreshInterval = time.Minute
+	}
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
+	return &KeyRing{
+		repository: repository,
+		config:     config,
+	}
+}
+
+// SigningKey returns the active key. If there is no active key or it is due for rotation, a new key is generated.
+func (k *KeyRing) SigningKey() (Key, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.loadIfStale(); err != nil {
+		return nil, err
+	}
+
+	active, ok := k.active()
+	if !ok || !k.config.Clock.Now().Before(active.record.CreatedAt.Add(k.config.RotationInterval)) {
+		if err := k.rotate(); err != nil {
+			return nil, err
+		}
+		active, _ = k.active()
+	}
+	return active.key, nil
+}
+
+func (k *KeyRing) VerificationKey(kid string) (Key, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.loadIfStale(); err != nil {
+		return nil, false
+	}
+
+	key, ok := k.find(kid)
+	// The key may have been created by another server instance since the keys were loaded.
+	if !ok && k.config.Clock.Now().Sub(k.loadedAt) > minimumKeyReloadInterval {
+		if err := k.load(); err != nil {
+			return nil, false
+		}
+		key, ok = k.find(kid)
+	}
+	return key, ok
+}
+
+func (k *KeyRing) VerificationKeys() ([]Key, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.loadIfStale(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key.key)
+	}
+	return keys, nil
+}
+
+// Rotate replaces the active key by a new key. The previously active key is retired and used for verification
+// until the grace period ends.
+func (k *KeyRing) Rotate() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.rotate()
+}
+
+func (k *KeyRing) rotate() error {
+	now := k.config.Clock.Now()
+	key, err := GenerateKey(k.config.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	record, err := marshalKey(key)
+	if err != nil {
+		return err
+	}
+	record.CreatedAt = now
+	if k.config.KmsClient != nil {
+		record, err = encryptKey(k.config.KmsClient, k.config.KmsKeyId, record)
+		if err != nil {
+			return err
+		}
+	}
+	if err := k.repository.SaveKey(record); err != nil {
+		return err
+	}
+
+	for _, previous := range k.keys {
+		if previous.record.RetiredAt.IsZero() {
+			if err := k.repository.RetireKey(previous.record.KeyId, now, now.Add(k.config.GracePeriod)); err != nil {
+				return err
+			}
+		}
+	}
+	return k.load()
+}
+
+func (k *KeyRing) loadIfStale() error {
+	if k.loadedAt.IsZero() || k.config.Clock.Now().Sub(k.loadedAt) >= k.config.RefreshInterval {
+		return k.load()
+	}
+	return nil
+}
+
+// load reads all keys from the repository, drops the ones whose grace period has ended
+// and sorts the remaining keys from newest to oldest.
+// Keys that can not be decrypted or parsed are logged and skipped, so that a single bad key does not break
+// the key ring. It only fails if none of the keys can be used.
+func (k *KeyRing) load() error {
+	now := k.config.Clock.Now()
+	records, err := k.repository.ListKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]ringKey, 0, len(records))
+	var loadErr error
+	for _, record := range records {
+		if !record.RetiredAt.IsZero() && !now.Before(record.ExpiresAt) {
+			continue
+		}
+		if k.config.KmsClient != nil && record.EncryptedDataKey == nil {
+			continue
+		}
+		// Keys that are already loaded are not decrypted again, which saves a KMS call per key and refresh.
+		key, ok := k.find(record.KeyId)
+		if !ok {
+			key, err = k.unmarshal(record)
+			if err != nil {
+				log.Printf("Skipping key %s of the key ring: %v", record.KeyId, err)
+				loadErr = err
+				continue
+			}
+		}
+		keys = append(keys, ringKey{key: key, record: record})
+	}
+	if len(keys) == 0 && loadErr != nil {
+		return loadErr
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].record.CreatedAt.After(keys[j].record.CreatedAt)
+	})
+	k.keys = keys
+	k.loadedAt = now
+	return nil
+}
+
+// active returns the newest key that is not retired.
+func (k *KeyRing) active() (ringKey, bool) {
+	for _, key := range k.keys {
+		if key.record.RetiredAt.IsZero() {
+			return key, true
+		}
+	}
+	return ringKey{}, false
+}
+
+func (k *KeyRing) find(kid string) (Key, bool) {
+	for _, key := range k.keys {
+		if key.key.Id() == kid {
+			return key.key, true
+		}
+	}
+	return nil, false
+}
+
+// unmarshal decrypts the material of the record, if it is encrypted, and parses the key.
+func (k *KeyRing) unmarshal(record repository.SigningKey) (Key, error) {
+	if record.EncryptedDataKey != nil {
+		if k.config.KmsClient == nil {
+			return nil, fmt.Errorf("key %s is encrypted, but the key ring has no KMS key", record.KeyId)
+		}
+		var err error
+		record, err = decryptKey(k.config.KmsClient, k.config.KmsKeyId, record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return unmarshalKey(record)
+}
+
+func marshalKey(key Key) (repository.SigningKey, error) {
+	var material []byte
+	var err error
+	switch key := key.(type) {
+	case *hmacKey:
+		material = key.secret
+	case *rsaKey:
+		material, err = x509.MarshalPKCS8PrivateKey(key.privateKey)
+	case *ecdsaKey:
+		material, err = x509.MarshalPKCS8PrivateKey(key.privateKey)
+	case *ed25519Key:
+		material, err = x509.MarshalPKCS8PrivateKey(key.privateKey)
+	default:
+		err = fmt.Errorf("key %s can not be stored", key.Id())
+	}
+	if err != nil {
+		return repository.SigningKey{}, err
+	}
+
+	return repository.SigningKey{
+		KeyId:     key.Id(),
+		Algorithm: key.Algorithm(),
+		Material:  material,
+	}, nil
+}
+
+func unmarshalKey(record repository.SigningKey) (Key, error) {
+	if record.Algorithm == AlgorithmHS256 {
+		return NewHmacKey(record.KeyId, record.Material), nil
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(record.Material)
+	if err != nil {
+		return nil, err
+	}
+
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return NewRsaKey(record.KeyId, privateKey), nil
+	case *ecdsa.PrivateKey:
+		return NewEcdsaKey(record.KeyId, privateKey)
+	case ed25519.PrivateKey:
+		return NewEd25519Key(record.KeyId, privateKey), nil
+	default:
+		return nil, fmt.Errorf("key %s has an unsupported type", record.KeyId)
+	}
+}
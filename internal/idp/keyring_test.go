@@ -0,0 +1,172 @@
+package idp_test
+
+import (
+	"crypto/x509"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"time"
+)
+
+type mutableClock struct {
+	now time.Time
+}
+
+func (c *mutableClock) Now() time.Time {
+	return c.now
+}
+
+func (suite *serverSuite) Test_KeyRing_VerifiesWithRetiredKeyDuringGracePeriod() {
+	// given a server with a key ring that keeps retired keys for two hours, and a token it issued
+	clock := &mutableClock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	keyRing := idp.NewKeyRing(repository.NewSimpleKeyRepository(), idp.KeyRingConfig{GracePeriod: 2 * time.Hour, Clock: clock})
+	server := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKeySet(keyRing), idp.WithClock(clock))
+	token := issueToken(suite, server)
+
+	// when the key ring is rotated
+	clock.now = clock.now.Add(10 * time.Minute)
+	err := keyRing.Rotate()
+	assert.NoError(suite.T(), err)
+
+	// then new tokens are signed with a new key and the old token is still valid
+	rotatedToken := issueToken(suite, server)
+	assert.NotEqual(suite.T(), decodeSegment(suite, token, 0)["kid"], decodeSegment(suite, rotatedToken, 0)["kid"])
+	assert.Equal(suite.T(), true, introspect(suite, server, token)["active"])
+	assert.Equal(suite.T(), true, introspect(suite, server, rotatedToken)["active"])
+
+	// and the retired key is no longer used after the grace period
+	clock.now = clock.now.Add(2 * time.Hour)
+	keys, err := keyRing.VerificationKeys()
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), keys, 1)
+	assert.Equal(suite.T(), decodeSegment(suite, rotatedToken, 0)["kid"], keys[0].Id())
+}
+
+func (suite *serverSuite) Test_KeyRing_RaisesGracePeriodToMaxTokenLifetime() {
+	// given a server with a key ring, whose grace period is shorter than the lifetime of its tokens, and a token it issued
+	clock := &mutableClock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	keyRing := idp.NewKeyRing(repository.NewSimpleKeyRepository(), idp.KeyRingConfig{GracePeriod: 5 * time.Minute, Clock: clock})
+	server := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKeySet(keyRing), idp.WithClock(clock))
+	token := issueToken(suite, server)
+
+	// when the key ring is rotated and the grace period has passed
+	clock.now = clock.now.Add(10 * time.Minute)
+	err := keyRing.Rotate()
+	assert.NoError(suite.T(), err)
+	clock.now = clock.now.Add(30 * time.Minute)
+
+	// then the token is still valid until it expires
+	assert.Equal(suite.T(), true, introspect(suite, server, token)["active"])
+}
+
+func (suite *serverSuite) Test_KeyRing_RotatesSigningKeyAfterRotationInterval() {
+	// given a server with a key ring that rotates every day
+	clock := &mutableClock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	keyRing := idp.NewKeyRing(repository.NewSimpleKeyRepository(), idp.KeyRingConfig{RotationInterval: 24 * time.Hour, Clock: clock})
+	server := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKeySet(keyRing), idp.WithClock(clock))
+	token := issueToken(suite, server)
+
+	// when the rotation interval has passed
+	clock.now = clock.now.Add(24 * time.Hour)
+	rotatedToken := issueToken(suite, server)
+
+	// then the token is signed with a new key
+	assert.NotEqual(suite.T(), decodeSegment(suite, token, 0)["kid"], decodeSegment(suite, rotatedToken, 0)["kid"])
+	keys, err := keyRing.VerificationKeys()
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), keys, 2)
+}
+
+func (suite *serverSuite) Test_KeyRing_SharesKeysBetweenServerInstances() {
+	// given two servers, whose key rings share a repository
+	keyRepository := repository.NewSimpleKeyRepository()
+	first := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKeySet(idp.NewKeyRing(keyRepository, idp.KeyRingConfig{})), idp.WithClock(suite.clock))
+	second := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKeySet(idp.NewKeyRing(keyRepository, idp.KeyRingConfig{})), idp.WithClock(suite.clock))
+
+	// when the first server issues a token
+	token := issueToken(suite, first)
+
+	// then the second server accepts it
+	assert.Equal(suite.T(), true, introspect(suite, second, token)["active"])
+}
+
+func (suite *serverSuite) Test_KeyRing_EncryptsKeysWithKms() {
+	// given two servers, whose key rings share a repository and encrypt their keys with a KMS key
+	kms, client := suite.startKms()
+	kms.AddSymmetricKey("key-ring-key", make([]byte, 32))
+	keyRepository := repository.NewSimpleKeyRepository()
+	config := idp.KeyRingConfig{KmsClient: client, KmsKeyId: "key-ring-key"}
+	first := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKeySet(idp.NewKeyRing(keyRepository, config)), idp.WithClock(suite.clock))
+	second := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKeySet(idp.NewKeyRing(keyRepository, config)), idp.WithClock(suite.clock))
+
+	// when the first server issues a token
+	token := issueToken(suite, first)
+
+	// then the stored key is encrypted
+	keys, err := keyRepository.ListKeys()
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), keys, 1)
+	assert.NotEmpty(suite.T(), keys[0].EncryptedDataKey)
+	_, err = x509.ParsePKCS8PrivateKey(keys[0].Material)
+	assert.Error(suite.T(), err)
+
+	// and the second server decrypts the key and accepts the token
+	assert.Equal(suite.T(), true, introspect(suite, second, token)["active"])
+}
+
+func (suite *serverSuite) Test_KeyRing_IgnoresUnencryptedKeysWithKms() {
+	// given an unencrypted key in the repository, for example planted by someone with write access
+	kms, client := suite.startKms()
+	kms.AddSymmetricKey("key-ring-key", make([]byte, 32))
+	keyRepository := repository.NewSimpleKeyRepository()
+	planted := idp.NewKeyRing(keyRepository, idp.KeyRingConfig{})
+	plantedKey, err := planted.SigningKey()
+	assert.NoError(suite.T(), err)
+
+	// when a key ring, which encrypts its keys with KMS, signs a token
+	keyRing := idp.NewKeyRing(keyRepository, idp.KeyRingConfig{KmsClient: client, KmsKeyId: "key-ring-key"})
+	signingKey, err := keyRing.SigningKey()
+	assert.NoError(suite.T(), err)
+
+	// then it uses a new key and does not accept the unencrypted key
+	assert.NotEqual(suite.T(), plantedKey.Id(), signingKey.Id())
+	_, ok := keyRing.VerificationKey(plantedKey.Id())
+	assert.False(suite.T(), ok)
+}
+
+func (suite *serverSuite) Test_KeyRing_SkipsKeysThatCanNotBeDecrypted() {
+	// given a key ring with a key, next to a newer key, whose data key can not be decrypted by KMS
+	kms, client := suite.startKms()
+	kms.AddSymmetricKey("key-ring-key", make([]byte, 32))
+	keyRepository := repository.NewSimpleKeyRepository()
+	config := idp.KeyRingConfig{KmsClient: client, KmsKeyId: "key-ring-key"}
+	first := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKeySet(idp.NewKeyRing(keyRepository, config)), idp.WithClock(suite.clock))
+	token := issueToken(suite, first)
+	corrupt := repository.SigningKey{
+		KeyId:            "corrupt",
+		Algorithm:        idp.AlgorithmES256,
+		Material:         []byte("corrupt"),
+		CreatedAt:        time.Now().Add(time.Minute),
+		EncryptedDataKey: []byte("corrupt"),
+	}
+	assert.NoError(suite.T(), keyRepository.SaveKey(corrupt))
+
+	// when another key ring loads the keys
+	keyRing := idp.NewKeyRing(keyRepository, config)
+	second := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKeySet(keyRing), idp.WithClock(suite.clock))
+
+	// then it skips the corrupt key and still signs and verifies tokens with the other key
+	keys, err := keyRing.VerificationKeys()
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), keys, 1)
+	signingKey, err := keyRing.SigningKey()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), decodeSegment(suite, token, 0)["kid"], signingKey.Id())
+	assert.Equal(suite.T(), true, introspect(suite, second, token)["active"])
+
+	// and a key ring, whose only key can not be decrypted, fails
+	onlyCorrupt := repository.NewSimpleKeyRepository()
+	assert.NoError(suite.T(), onlyCorrupt.SaveKey(corrupt))
+	_, err = idp.NewKeyRing(onlyCorrupt, config).VerificationKeys()
+	assert.Error(suite.T(), err)
+}
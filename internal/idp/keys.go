@@ -0,0 +1,246 @@
+package idp
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/thanhpk/randstr"
+	"math/big"
+)
+
+// Signing algorithms supported by the keys of the server.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Key signs tokens and verifies their signatures.
+// Every token signed by the server carries the id of its key in the kid header,
+// so that the key can be selected again when the token is verified.
+type Key interface {
+	// Id returns the key id, which is set as kid header of the tokens signed with the key.
+	Id() string
+	// Algorithm returns the JWS algorithm of the key, which is set as alg header of the tokens signed with the key.
+	Algorithm() string
+	// Sign returns the base64url encoded signature of the signing string.
+	Sign(signingString string) (string, error)
+	// Verify checks the base64url encoded signature of the signing string.
+	Verify(signingString string, signature string) error
+	// Jwk returns the public key as JSON Web Key, or nil for symmetric keys, which must never be published.
+	Jwk() *Jwk
+}
+
+// Jwk is a public key in the JSON Web Key format of RFC 7517.
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type hmacKey struct {
+	id     string
+	secret []byte
+}
+
+// NewHmacKey creates a symmetric HS256 key. Tokens signed with it can only be verified by the server itself.
+func NewHmacKey(id string, secret []byte) Key {
+	return &hmacKey{id: id, secret: secret}
+}
+
+func (k *hmacKey) Id() string        { return k.id }
+func (k *hmacKey) Algorithm() string { return AlgorithmHS256 }
+func (k *hmacKey) Jwk() *Jwk         { return nil }
+
+func (k *hmacKey) Sign(signingString string) (string, error) {
+	return jwt.SigningMethodHS256.Sign(signingString, k.secret)
+}
+
+func (k *hmacKey) Verify(signingString string, signature string) error {
+	return jwt.SigningMethodHS256.Verify(signingString, signature, k.secret)
+}
+
+type rsaKey struct {
+	id         string
+	privateKey *rsa.PrivateKey
+}
+
+// NewRsaKey creates an RS256 key.
+func NewRsaKey(id string, privateKey *rsa.PrivateKey) Key {
+	return &rsaKey{id: id, privateKey: privateKey}
+}
+
+func (k *rsaKey) Id() string        { return k.id }
+func (k *rsaKey) Algorithm() string { return AlgorithmRS256 }
+
+func (k *rsaKey) Sign(signingString string) (string, error) {
+	return jwt.SigningMethodRS256.Sign(signingString, k.privateKey)
+}
+
+func (k *rsaKey) Verify(signingString string, signature string) error {
+	return jwt.SigningMethodRS256.Verify(signingString, signature, &k.privateKey.PublicKey)
+}
+
+func (k *rsaKey) Jwk() *Jwk {
+	return &Jwk{
+		Kty: "RSA",
+		Kid: k.id,
+		Use: "sig",
+		Alg: AlgorithmRS256,
+		N:   base64.RawURLEncoding.EncodeToString(k.privateKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.privateKey.E)).Bytes()),
+	}
+}
+
+type ecdsaKey struct {
+	id         string
+	privateKey *ecdsa.PrivateKey
+}
+
+// NewEcdsaKey creates an ES256 key. The key must use the P-256 curve.
+func NewEcdsaKey(id string, privateKey *ecdsa.PrivateKey) (Key, error) {
+	if privateKey.Curve != elliptic.P256() {
+		return nil, errors.New("ES256 requires a key on the P-256 curve")
+	}
+	return &ecdsaKey{id: id, privateKey: privateKey}, nil
+}
+
+func (k *ecdsaKey) Id() string        { return k.id }
+func (k *ecdsaKey) Algorithm() string { return AlgorithmES256 }
+
+func (k *ecdsaKey) Sign(signingString string) (string, error) {
+	return jwt.SigningMethodES256.Sign(signingString, k.privateKey)
+}
+
+func (k *ecdsaKey) Verify(signingString string, signature string) error {
+	return jwt.SigningMethodES256.Verify(signingString, signature, &k.privateKey.PublicKey)
+}
+
+func (k *ecdsaKey) Jwk() *Jwk {
+	// The coordinates must be padded to the size of the curve, see RFC 7518 section 6.2.1.2.
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	k.privateKey.X.FillBytes(x)
+	k.privateKey.Y.FillBytes(y)
+	return &Jwk{
+		Kty: "EC",
+		Kid: k.id,
+		Use: "sig",
+		Alg: AlgorithmES256,
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(x),
+		Y:   base64.RawURLEncoding.EncodeToString(y),
+	}
+}
+
+type ed25519Key struct {
+	id         string
+	privateKey ed25519.PrivateKey
+}
+
+// NewEd25519Key creates an EdDSA key on the Ed25519 curve as described in RFC 8037.
+func NewEd25519Key(id string, privateKey ed25519.PrivateKey) Key {
+	return &ed25519Key{id: id, privateKey: privateKey}
+}
+
+func (k *ed25519Key) Id() string        { return k.id }
+func (k *ed25519Key) Algorithm() string { return AlgorithmEdDSA }
+
+func (k *ed25519Key) Sign(signingString string) (string, error) {
+	return signingMethodEdDSA.Sign(signingString, k.privateKey)
+}
+
+func (k *ed25519Key) Verify(signingString string, signature string) error {
+	return signingMethodEdDSA.Verify(signingString, signature, k.privateKey.Public())
+}
+
+func (k *ed25519Key) Jwk() *Jwk {
+	return &Jwk{
+		Kty: "OKP",
+		Kid: k.id,
+		Use: "sig",
+		Alg: AlgorithmEdDSA,
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(k.privateKey.Public().(ed25519.PublicKey)),
+	}
+}
+
+// edDSASigningMethod implements the EdDSA algorithm of RFC 8037, which is not part of jwt-go.
+type edDSASigningMethod struct{}
+
+var signingMethodEdDSA = &edDSASigningMethod{}
+
+func init() {
+	jwt.RegisterSigningMethod(AlgorithmEdDSA, func() jwt.SigningMethod {
+		return signingMethodEdDSA
+	})
+}
+
+func (m *edDSASigningMethod) Alg() string {
+	return AlgorithmEdDSA
+}
+
+func (m *edDSASigningMethod) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *edDSASigningMethod) Verify(signingString string, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	decoded, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), decoded) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// GenerateKey creates a new key with a random key id for the given algorithm.
+func GenerateKey(algorithm string) (Key, error) {
+	id := randstr.String(16)
+	switch algorithm {
+	case AlgorithmHS256:
+		return NewHmacKey(id, randstr.Bytes(32)), nil
+	case AlgorithmRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return NewRsaKey(id, privateKey), nil
+	case AlgorithmES256:
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return NewEcdsaKey(id, privateKey)
+	case AlgorithmEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return NewEd25519Key(id, privateKey), nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+}
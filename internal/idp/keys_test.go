@@ -0,0 +1,101 @@
+package idp_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *serverSuite) Test_TokenEndpoint_SignsWithAsymmetricKeys() {
+	for _, algorithm := range []string{idp.AlgorithmRS256, idp.AlgorithmES256, idp.AlgorithmEdDSA} {
+		suite.Run(algorithm, func() {
+			// given a server with an asymmetric key
+			key, err := idp.GenerateKey(algorithm)
+			assert.NoError(suite.T(), err)
+			server := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKey(key), idp.WithClock(suite.clock))
+
+			// when issuing a token
+			requestBody := `{"client_id":"1234567890","client_secret":"client_secret","grant_type":"client_credentials"}`
+			response := httptest.NewRecorder()
+			server.TokenHandler(response, httptest.NewRequest(http.MethodPost, "/token", bytes.NewBufferString(requestBody)))
+			var jsonTokenResponse tokenResponse
+			err = json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+			assert.NoError(suite.T(), err)
+
+			// then the token carries the algorithm and key id and can be introspected
+			header := decodeSegment(suite, jsonTokenResponse.AccessToken, 0)
+			assert.Equal(suite.T(), algorithm, header["alg"])
+			assert.Equal(suite.T(), key.Id(), header["kid"])
+			assert.Equal(suite.T(), true, introspect(suite, server, jsonTokenResponse.AccessToken)["active"])
+		})
+	}
+}
+
+func (suite *serverSuite) Test_IntrospectEndpoint_ReturnsIsNotActiveIfAlgorithmDoesNotMatchKey() {
+	// given a server with an RSA key
+	key, err := idp.GenerateKey(idp.AlgorithmRS256)
+	assert.NoError(suite.T(), err)
+	server := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKey(key), idp.WithClock(suite.clock))
+
+	// when introspecting an HS256 token that references the RSA key, signed with its public modulus
+	signingString := encodeSegment(map[string]interface{}{"alg": "HS256", "kid": key.Id(), "typ": "JWT"}) + "." +
+		encodeSegment(map[string]interface{}{"exp": 10413795600, "sub": "1234567890"})
+	signature, err := idp.NewHmacKey(key.Id(), []byte(key.Jwk().N)).Sign(signingString)
+	assert.NoError(suite.T(), err)
+	introspection := introspect(suite, server, signingString+"."+signature)
+
+	// then the token is inactive
+	assert.Equal(suite.T(), map[string]interface{}{"active": false}, introspection)
+}
+
+func (suite *serverSuite) Test_JwksEndpoint_PublishesPublicKey() {
+	// given a server with an ECDSA key
+	key, err := idp.GenerateKey(idp.AlgorithmES256)
+	assert.NoError(suite.T(), err)
+	server := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKey(key))
+
+	// when requesting the JSON Web Key Set
+	response := httptest.NewRecorder()
+	server.JwksHandler(response, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	// then the public key is returned
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	var jwks struct {
+		Keys []idp.Jwk `json:"keys"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&jwks)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []idp.Jwk{*key.Jwk()}, jwks.Keys)
+	assert.Equal(suite.T(), "EC", jwks.Keys[0].Kty)
+	assert.Equal(suite.T(), "P-256", jwks.Keys[0].Crv)
+}
+
+func (suite *serverSuite) Test_JwksEndpoint_DoesNotPublishSymmetricKeys() {
+	// when requesting the JSON Web Key Set of a server with an HS256 key
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	response := httptest.NewRecorder()
+	suite.InitIdpApi().ServeHTTP(response, request)
+
+	// then no key is returned
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"keys\":[]}\n", response.Body.String())
+}
+
+func decodeSegment(suite *serverSuite, token string, index int) map[string]interface{} {
+	segment, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[index])
+	assert.NoError(suite.T(), err)
+	decoded := map[string]interface{}{}
+	err = json.Unmarshal(segment, &decoded)
+	assert.NoError(suite.T(), err)
+	return decoded
+}
+
+func encodeSegment(value map[string]interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
@@ -0,0 +1,262 @@
+package idp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+)
+
+// KmsClient is the subset of the AWS KMS API that is used to sign and verify tokens and to encrypt the keys of a KeyRing.
+// It is implemented by *kms.Client.
+type KmsClient interface {
+	GenerateMac(ctx context.Context, params *kms.GenerateMacInput, optFns ...func(*kms.Options)) (*kms.GenerateMacOutput, error)
+	VerifyMac(ctx context.Context, params *kms.VerifyMacInput, optFns ...func(*kms.Options)) (*kms.VerifyMacOutput, error)
+	Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error)
+	GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error)
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+type kmsHmacKey struct {
+	id       string
+	client   KmsClient
+	kmsKeyId string
+}
+
+// NewKmsHmacKey creates an HS256 key, which signs and verifies tokens with the GenerateMac and VerifyMac operations
+// of an HMAC_256 KMS key, so that the key material never leaves KMS.
+func NewKmsHmacKey(id string, client KmsClient, kmsKeyId string) Key {
+	return &kmsHmacKey{id: id, client: client, kmsKeyId: kmsKeyId}
+}
+
+func (k *kmsHmacKey) Id() string        { return k.id }
+func (k *kmsHmacKey) Algorithm() string { return AlgorithmHS256 }
+func (k *kmsHmacKey) Jwk() *Jwk         { return nil }
+
+func (k *kmsHmacKey) Sign(signingString string) (string, error) {
+	output, err := k.client.GenerateMac(context.TODO(), &kms.GenerateMacInput{
+		KeyId:        aws.String(k.kmsKeyId),
+		MacAlgorithm: types.MacAlgorithmSpecHmacSha256,
+		Message:      []byte(signingString),
+	})
+	if err != nil {
+		return "", err
+	}
+	return jwt.EncodeSegment(output.Mac), nil
+}
+
+func (k *kmsHmacKey) Verify(signingString string, signature string) error {
+	mac, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	// KMS answers an invalid MAC with a KMSInvalidMacException, so every error means the signature is not valid.
+	output, err := k.client.VerifyMac(context.TODO(), &kms.VerifyMacInput{
+		KeyId:        aws.String(k.kmsKeyId),
+		MacAlgorithm: types.MacAlgorithmSpecHmacSha256,
+		Message:      []byte(signingString),
+		Mac:          mac,
+	})
+	if err != nil || !output.MacValid {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+type kmsSigningKey struct {
+	id        string
+	client    KmsClient
+	kmsKeyId  string
+	algorithm string
+	// publicKey verifies signatures locally, which avoids a KMS call for every verification.
+	publicKey Key
+}
+
+// NewKmsSigningKey creates an RS256 or ES256 key, which signs tokens with the Sign operation of an asymmetric
+// RSA_2048 or ECC_NIST_P256 KMS key. The public key is fetched once and used to verify tokens locally.
+func NewKmsSigningKey(id string, client KmsClient, kmsKeyId string) (Key, error) {
+	output, err := client.GetPublicKey(context.TODO(), &kms.GetPublicKeyInput{
+		KeyId: aws.String(kmsKeyId),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(output.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &kmsSigningKey{id: id, client: client, kmsKeyId: kmsKeyId}
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		key.algorithm = AlgorithmRS256
+		key.publicKey = &rsaKey{id: id, privateKey: &rsa.PrivateKey{PublicKey: *publicKey}}
+	case *ecdsa.PublicKey:
+		publicEcdsaKey, err := NewEcdsaKey(id, &ecdsa.PrivateKey{PublicKey: *publicKey})
+		if err != nil {
+			return nil, err
+		}
+		key.algorithm = AlgorithmES256
+		key.publicKey = publicEcdsaKey
+	default:
+		return nil, fmt.Errorf("KMS key %s has an unsupported key spec %s", kmsKeyId, output.KeySpec)
+	}
+	return key, nil
+}
+
+func (k *kmsSigningKey) Id() string        { return k.id }
+func (k *kmsSigningKey) Algorithm() string { return k.algorithm }
+func (k *kmsSigningKey) Jwk() *Jwk         { return k.publicKey.Jwk() }
+
+func (k *kmsSigningKey) Sign(signingString string) (string, error) {
+	signingAlgorithm := types.SigningAlgorithmSpecRsassaPkcs1V15Sha256
+	if k.algorithm == AlgorithmES256 {
+		signingAlgorithm = types.SigningAlgorithmSpecEcdsaSha256
+	}
+
+	digest := sha256.Sum256([]byte(signingString))
+	output, err := k.client.Sign(context.TODO(), &kms.SignInput{
+		KeyId:            aws.String(k.kmsKeyId),
+		Message:          digest[:],
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: signingAlgorithm,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signature := output.Signature
+	if k.algorithm == AlgorithmES256 {
+		signature, err = derToJwsSignature(signature)
+		if err != nil {
+			return "", err
+		}
+	}
+	return jwt.EncodeSegment(signature), nil
+}
+
+func (k *kmsSigningKey) Verify(signingString string, signature string) error {
+	return k.publicKey.Verify(signingString, signature)
+}
+
+// derToJwsSignature converts the ASN.1 DER encoded ECDSA signature of KMS into the fixed size R || S format of RFC 7518 section 3.4.
+func derToJwsSignature(der []byte) ([]byte, error) {
+	var signature struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &signature); err != nil {
+		return nil, err
+	}
+
+	jws := make([]byte, 64)
+	signature.R.FillBytes(jws[:32])
+	signature.S.FillBytes(jws[32:])
+	return jws, nil
+}
+
+// encryptKey envelope encrypts the material of the key with a new AES-256 data key of the KMS key.
+// The key id is bound to the ciphertext as encryption context and additional data, so that the material
+// of one key can not be swapped with the material of another key.
+func encryptKey(client KmsClient, kmsKeyId string, record repository.SigningKey) (repository.SigningKey, error) {
+	output, err := client.GenerateDataKey(context.TODO(), &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(kmsKeyId),
+		KeySpec:           types.DataKeySpecAes256,
+		EncryptionContext: map[string]string{"keyId": record.KeyId},
+	})
+	if err != nil {
+		return repository.SigningKey{}, err
+	}
+
+	gcm, err := newGcm(output.Plaintext)
+	if err != nil {
+		return repository.SigningKey{}, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return repository.SigningKey{}, err
+	}
+	record.Material = gcm.Seal(nonce, nonce, record.Material, []byte(record.KeyId))
+	record.EncryptedDataKey = output.CiphertextBlob
+	return record, nil
+}
+
+// decryptKey decrypts the data key of an envelope encrypted key with the KMS key and then the material of the key.
+func decryptKey(client KmsClient, kmsKeyId string, record repository.SigningKey) (repository.SigningKey, error) {
+	output, err := client.Decrypt(context.TODO(), &kms.DecryptInput{
+		KeyId:             aws.String(kmsKeyId),
+		CiphertextBlob:    record.EncryptedDataKey,
+		EncryptionContext: map[string]string{"keyId": record.KeyId},
+	})
+	if err != nil {
+		return repository.SigningKey{}, err
+	}
+
+	gcm, err := newGcm(output.Plaintext)
+	if err != nil {
+		return repository.SigningKey{}, err
+	}
+	if len(record.Material) < gcm.NonceSize() {
+		return repository.SigningKey{}, fmt.Errorf("key %s has invalid material", record.KeyId)
+	}
+	material, err := gcm.Open(nil, record.Material[:gcm.NonceSize()], record.Material[gcm.NonceSize():], []byte(record.KeyId))
+	if err != nil {
+		return repository.SigningKey{}, err
+	}
+	record.Material = material
+	record.EncryptedDataKey = nil
+	return record, nil
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func NewKmsClient() *kms.Client {
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion("us-east-1"),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return kms.NewFromConfig(cfg)
+}
+
+// NewLocalKmsClient creates a KMS client for a local stand-in of KMS, like the one of the kmslocal package.
+func NewLocalKmsClient(endpoint string) *kms.Client {
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion("eu-west-1"),
+		config.WithCredentialsProvider(credentials.StaticCredentialsProvider{
+			Value: aws.Credentials{
+				AccessKeyID: "DUMMYIDEXAMPLE", SecretAccessKey: "DUMMYEXAMPLEKEY", SessionToken: "dummy",
+				Source: "Hard-coded credentials; values are irrelevant for local KMS",
+			},
+		}),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return kms.NewFromConfig(cfg, func(o *kms.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+}
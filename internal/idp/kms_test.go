@@ -0,0 +1,73 @@
+package idp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/daschaa/open-idp/internal/kmslocal"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *serverSuite) startKms() (*kmslocal.Server, idp.KmsClient) {
+	kms := kmslocal.New()
+	server := httptest.NewServer(kms)
+	suite.T().Cleanup(server.Close)
+	return kms, idp.NewLocalKmsClient(server.URL)
+}
+
+func (suite *serverSuite) Test_KmsHmacKey_SignsAndVerifiesTokens() {
+	// given a server that signs with an HMAC key in KMS
+	kms, client := suite.startKms()
+	kms.AddHmacKey("hmac-key", []byte("kms_secret"))
+	server := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKey(idp.NewKmsHmacKey("kms", client, "hmac-key")), idp.WithClock(suite.clock))
+
+	// when issuing a token
+	token := issueToken(suite, server)
+
+	// then the token is signed with HS256 and verified by KMS
+	assert.Equal(suite.T(), "HS256", decodeSegment(suite, token, 0)["alg"])
+	assert.Equal(suite.T(), "kms", decodeSegment(suite, token, 0)["kid"])
+	assert.Equal(suite.T(), true, introspect(suite, server, token)["active"])
+	tampered := token[:strings.LastIndex(token, ".")] + ".c2lnbmF0dXJl"
+	assert.Equal(suite.T(), false, introspect(suite, server, tampered)["active"])
+}
+
+func (suite *serverSuite) Test_KmsSigningKey_SignsTokensWithAsymmetricKeys() {
+	kms, client := suite.startKms()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(suite.T(), err)
+	kms.AddRsaKey("rsa-key", rsaKey)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(suite.T(), err)
+	kms.AddEcdsaKey("ecdsa-key", ecdsaKey)
+
+	for kmsKeyId, algorithm := range map[string]string{"rsa-key": idp.AlgorithmRS256, "ecdsa-key": idp.AlgorithmES256} {
+		suite.Run(algorithm, func() {
+			// given a server that signs with an asymmetric key in KMS
+			key, err := idp.NewKmsSigningKey("kms", client, kmsKeyId)
+			assert.NoError(suite.T(), err)
+			server := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithKey(key), idp.WithClock(suite.clock))
+
+			// when issuing a token
+			token := issueToken(suite, server)
+
+			// then the token is signed with the algorithm of the key and can be verified with its public key
+			assert.Equal(suite.T(), algorithm, decodeSegment(suite, token, 0)["alg"])
+			assert.Equal(suite.T(), algorithm, key.Jwk().Alg)
+			assert.Equal(suite.T(), true, introspect(suite, server, token)["active"])
+		})
+	}
+}
+
+func (suite *serverSuite) Test_KmsSigningKey_ReturnsErrorIfKeyIsUnknown() {
+	// when creating a key for a KMS key that does not exist
+	_, client := suite.startKms()
+	_, err := idp.NewKmsSigningKey("kms", client, "unknown-key")
+
+	// then an error is returned
+	assert.ErrorContains(suite.T(), err, "NotFoundException")
+}
@@ -0,0 +1,249 @@
+package idp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/daschaa/open-idp/internal/secret"
+	"github.com/thanhpk/randstr"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookieName = "open_idp_session"
+	sessionLifetime   = 8 * time.Hour
+	// csrfCookieName is the cookie of the anti-CSRF token of the login form, which is submitted again as form field.
+	csrfCookieName = "open_idp_csrf"
+	// acrPassword is the acr of a single-factor password authentication.
+	acrPassword = "1"
+
+	defaultMaxFailedLogins = 5
+	defaultLockoutDuration = 15 * time.Minute
+)
+
+var (
+	errInvalidCredentials = errors.New("invalid username or password")
+	errUserLocked         = errors.New("user is locked")
+)
+
+// dummyPasswordHash is verified for unknown usernames, so that the response time does not reveal which usernames exist.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword(randstr.String(16))
+	return hash
+})
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Log in</title></head>
+<body>
+<form method="post">
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<input type="hidden" name="return_to" value="{{.ReturnTo}}">
+<input type="hidden" name="csrf_token" value="{{.CsrfToken}}">
+<label>Username <input name="username" value="{{.Username}}" autocomplete="username"></label>
+<label>Password <input name="password" type="password" autocomplete="current-password"></label>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+type loginForm struct {
+	Error     string
+	ReturnTo  string
+	Username  string
+	CsrfToken string
+}
+
+// LoginHandler logs end users in with their username and password and starts a session, which the Authenticator
+// of WithLogin uses to authenticate authorization requests.
+// A GET request renders the login form. A POST request with the form fields username, password and return_to
+// checks the credentials, sets the session cookie and redirects to return_to, which must be a path on this server.
+//
+// The form carries an anti-CSRF token, which is also set as cookie when the form is rendered, so that other sites
+// can not log the browser in to an account of their choice. A POST request must send the token of the cookie
+// as csrf_token form field.
+//
+// If the anti-CSRF token is missing or does not match the cookie, it renders the form again with a 403 Forbidden status.
+// If the credentials are invalid or the user is locked, it renders the form again with a 401 Unauthorized status.
+// After too many failed logins in a row, the user is locked for a while, as configured with WithLockout.
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	form := loginForm{ReturnTo: r.FormValue("return_to"), Username: r.PostFormValue("username")}
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		form.CsrfToken = s.setCsrfCookie(w, r)
+	} else {
+		form.CsrfToken = cookie.Value
+	}
+	if r.Method != http.MethodPost {
+		renderLoginPage(w, http.StatusOK, form)
+		return
+	}
+	if err != nil || subtle.ConstantTimeCompare([]byte(form.CsrfToken), []byte(r.PostFormValue("csrf_token"))) != 1 {
+		form.Error = "Your login form expired. Please try again."
+		renderLoginPage(w, http.StatusForbidden, form)
+		return
+	}
+
+	user, err := s.authenticateUser(form.Username, r.PostFormValue("password"))
+	if errors.Is(err, errInvalidCredentials) {
+		form.Error = "Invalid username or password."
+		renderLoginPage(w, http.StatusUnauthorized, form)
+		return
+	}
+	if errors.Is(err, errUserLocked) {
+		form.Error = "Too many failed logins. Please try again later."
+		renderLoginPage(w, http.StatusUnauthorized, form)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	now := s.clock.Now()
+	token := randstr.String(32)
+	err = s.sessionRepository.SaveSession(repository.Session{
+		SessionHash: hashToken(token),
+		Subject:     user.Subject,
+		AuthTime:    now,
+		ExpiresAt:   now.Add(sessionLifetime),
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  now.Add(sessionLifetime),
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	if isLocalPath(form.ReturnTo) {
+		http.Redirect(w, r, form.ReturnTo, http.StatusFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setCsrfCookie sets a new anti-CSRF token as cookie for the login form and returns it.
+// The cookie is restricted to the login path and is not sent with requests of other sites.
+func (s *Server) setCsrfCookie(w http.ResponseWriter, r *http.Request) string {
+	token := randstr.String(32)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     r.URL.Path,
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// secureCookies reports whether cookies are only sent over HTTPS, which is the case if the server is reachable with HTTPS.
+func (s *Server) secureCookies(r *http.Request) bool {
+	return r.TLS != nil || strings.HasPrefix(s.issuer, "https://")
+}
+
+// LogoutHandler ends the session of the end user and removes the session cookie.
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := s.sessionRepository.DeleteSession(hashToken(cookie.Value)); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Path: "/", MaxAge: -1, HttpOnly: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticateUser checks the password of the user with the username.
+// Failed attempts are counted per user, and the user is locked once the count reaches the maximum of the server.
+// It returns errInvalidCredentials for unknown users and wrong passwords alike, and errUserLocked for locked users.
+func (s *Server) authenticateUser(username string, password string) (*repository.User, error) {
+	user, err := s.userRepository.GetUserByUsername(username)
+	var notFound repository.UserNotFound
+	if errors.As(err, &notFound) {
+		secret.Verify(dummyPasswordHash(), password)
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	if now.Before(user.LockedUntil) {
+		return nil, errUserLocked
+	}
+
+	if !secret.Verify(user.PasswordHash, password) {
+		failedLogins, err := s.userRepository.RecordFailedLogin(user.Subject)
+		if err != nil {
+			return nil, err
+		}
+		if failedLogins >= s.maxFailedLogins {
+			if err := s.userRepository.LockUser(user.Subject, now.Add(s.lockoutDuration)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, errInvalidCredentials
+	}
+
+	if user.FailedLogins > 0 {
+		if err := s.userRepository.ResetFailedLogins(user.Subject); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func renderLoginPage(w http.ResponseWriter, status int, form loginForm) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = loginPage.Execute(w, form)
+}
+
+// isLocalPath reports whether the URL is a path on this server, so that the login can not redirect to another site.
+// Browsers strip tabs and newlines from URLs and treat backslashes like slashes, so URLs with control characters
+// or backslashes are rejected, because for example /\t/evil.example.com would become //evil.example.com.
+func isLocalPath(uri string) bool {
+	if strings.ContainsFunc(uri, func(r rune) bool { return r < 0x20 || r == 0x7f || r == '\\' }) {
+		return false
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" {
+		return false
+	}
+	return strings.HasPrefix(uri, "/") && !strings.HasPrefix(uri, "//")
+}
+
+// sessionAuthenticator authenticates the end user with the session of the LoginHandler,
+// and redirects users without session to the login page.
+type sessionAuthenticator struct {
+	server    *Server
+	loginPath string
+}
+
+func (a sessionAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*Authentication, bool) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		session, err := a.server.sessionRepository.GetSession(hashToken(cookie.Value))
+		if err == nil && a.server.clock.Now().Before(session.ExpiresAt) {
+			return &Authentication{Subject: session.Subject, AuthTime: session.AuthTime, Acr: acrPassword}, true
+		}
+	}
+
+	returnTo := r.URL.Path + "?" + r.Form.Encode()
+	http.Redirect(w, r, a.loginPath+"?"+url.Values{"return_to": {returnTo}}.Encode(), http.StatusFound)
+	return nil, false
+}
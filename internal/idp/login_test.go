@@ -0,0 +1,245 @@
+package idp_test
+
+import (
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+// loginApi returns the API of a server, which logs in end users at /login.
+// The user alice has an argon2id hash of the password "correct horse", the user bob a bcrypt hash of "battery staple".
+// The client cli may use the password grant, if it is enabled with the options.
+func (suite *serverSuite) loginApi(opts ...idp.ServerOption) http.Handler {
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:           "cli",
+		ClientSecret:       "cli_secret",
+		Scopes:             []string{"read:example"},
+		AllowPasswordGrant: true,
+	})
+	assert.NoError(suite.T(), err)
+
+	users := repository.NewSimpleUserRepository()
+	argon2Hash, err := idp.HashPassword("correct horse")
+	assert.NoError(suite.T(), err)
+	err = users.SaveUser(repository.User{Subject: "alice", Username: "alice", PasswordHash: argon2Hash})
+	assert.NoError(suite.T(), err)
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("battery staple"), bcrypt.MinCost)
+	assert.NoError(suite.T(), err)
+	err = users.SaveUser(repository.User{Subject: "bob", Username: "bob", PasswordHash: string(bcryptHash)})
+	assert.NoError(suite.T(), err)
+
+	opts = append([]idp.ServerOption{
+		idp.WithSigningKey(&suite.signingKey),
+		idp.WithClock(suite.clock),
+		idp.WithIssuer("https://idp.example.com"),
+		idp.WithAudience("https://api.example.com"),
+		idp.WithUserRepository(users),
+		idp.WithLogin("/login"),
+	}, opts...)
+	server := suite.newServer(opts...)
+	router := mux.NewRouter()
+	router.HandleFunc("/authorize", server.AuthorizeHandler)
+	router.HandleFunc("/token", server.TokenHandler)
+	router.HandleFunc("/.well-known/oauth-authorization-server", server.MetadataHandler)
+	router.HandleFunc("/login", server.LoginHandler)
+	router.HandleFunc("/logout", server.LogoutHandler)
+	return router
+}
+
+// login renders the login form and submits it with the anti-CSRF token of its cookie.
+func login(api http.Handler, username string, password string, returnTo string) *httptest.ResponseRecorder {
+	page := httptest.NewRecorder()
+	api.ServeHTTP(page, httptest.NewRequest(http.MethodGet, "/login", nil))
+	csrfCookie := page.Result().Cookies()[0]
+
+	form := url.Values{"username": {username}, "password": {password}, "return_to": {returnTo}, "csrf_token": {csrfCookie.Value}}
+	request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.AddCookie(csrfCookie)
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *serverSuite) Test_LoginEndpoint_RendersLoginForm() {
+	// when requesting the login page
+	response := httptest.NewRecorder()
+	suite.loginApi().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/login?return_to=%2Fauthorize", nil))
+
+	// then the login form is returned with the return_to URL
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), `<input type="hidden" name="return_to" value="/authorize">`)
+
+	// and with the anti-CSRF token of its cookie
+	cookies := response.Result().Cookies()
+	assert.Len(suite.T(), cookies, 1)
+	assert.Equal(suite.T(), "open_idp_csrf", cookies[0].Name)
+	assert.Equal(suite.T(), http.SameSiteStrictMode, cookies[0].SameSite)
+	assert.Contains(suite.T(), response.Body.String(), `<input type="hidden" name="csrf_token" value="`+cookies[0].Value+`">`)
+}
+
+func (suite *serverSuite) Test_LoginEndpoint_RejectsMissingOrMismatchedCsrfToken() {
+	api := suite.loginApi()
+	page := httptest.NewRecorder()
+	api.ServeHTTP(page, httptest.NewRequest(http.MethodGet, "/login", nil))
+	csrfCookie := page.Result().Cookies()[0]
+
+	for name, csrf := range map[string]struct {
+		cookie *http.Cookie
+		token  string
+	}{
+		"missing token":      {cookie: csrfCookie},
+		"missing cookie":     {token: csrfCookie.Value},
+		"mismatched token":   {cookie: csrfCookie, token: "forged"},
+		"missing everything": {},
+	} {
+		// when logging in with valid credentials, but without a matching anti-CSRF token and cookie
+		form := url.Values{"username": {"alice"}, "password": {"correct horse"}}
+		if csrf.token != "" {
+			form.Set("csrf_token", csrf.token)
+		}
+		request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if csrf.cookie != nil {
+			request.AddCookie(csrf.cookie)
+		}
+		response := httptest.NewRecorder()
+		api.ServeHTTP(response, request)
+
+		// then the response is 403 Forbidden and no session is started
+		assert.Equal(suite.T(), http.StatusForbidden, response.Result().StatusCode, name)
+		assert.Contains(suite.T(), response.Body.String(), "Your login form expired.", name)
+		for _, cookie := range response.Result().Cookies() {
+			assert.NotEqual(suite.T(), "open_idp_session", cookie.Name, name)
+		}
+	}
+}
+
+func (suite *serverSuite) Test_LoginEndpoint_StartsSessionAndRedirects() {
+	// when logging in with a valid username and password
+	response := login(suite.loginApi(), "alice", "correct horse", "/authorize?client_id=1234567890")
+
+	// then a session cookie is set and the user is redirected to the return_to URL
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	assert.Equal(suite.T(), "/authorize?client_id=1234567890", response.Header().Get("Location"))
+	cookies := response.Result().Cookies()
+	assert.Len(suite.T(), cookies, 1)
+	assert.Equal(suite.T(), "open_idp_session", cookies[0].Name)
+	assert.True(suite.T(), cookies[0].HttpOnly)
+}
+
+func (suite *serverSuite) Test_LoginEndpoint_AcceptsBcryptHashes() {
+	// when logging in as a user with a bcrypt password hash
+	response := login(suite.loginApi(), "bob", "battery staple", "")
+
+	// then the user is logged in
+	assert.Equal(suite.T(), http.StatusNoContent, response.Result().StatusCode)
+	assert.Len(suite.T(), response.Result().Cookies(), 1)
+}
+
+func (suite *serverSuite) Test_LoginEndpoint_DoesNotRedirectToOtherSites() {
+	// when logging in with a return_to URL of another site
+	for _, returnTo := range []string{
+		"https://evil.example.com",
+		"//evil.example.com",
+		"/\\evil.example.com",
+		"/\t/evil.example.com",
+		"/\n/evil.example.com",
+		"/\r/evil.example.com",
+		"/x\\evil.example.com",
+		"javascript:alert(1)",
+	} {
+		response := login(suite.loginApi(), "alice", "correct horse", returnTo)
+
+		// then the user is not redirected
+		assert.Equal(suite.T(), http.StatusNoContent, response.Result().StatusCode, returnTo)
+		assert.Empty(suite.T(), response.Header().Get("Location"), returnTo)
+	}
+}
+
+func (suite *serverSuite) Test_LoginEndpoint_RejectsInvalidCredentials() {
+	// when logging in with a wrong password or an unknown username
+	api := suite.loginApi()
+	for _, username := range []string{"alice", "mallory"} {
+		response := login(api, username, "wrong password", "/authorize")
+
+		// then the response is 401 Unauthorized with the same message, and no session is started
+		assert.Equal(suite.T(), http.StatusUnauthorized, response.Result().StatusCode, username)
+		assert.Contains(suite.T(), response.Body.String(), "Invalid username or password.", username)
+		assert.Empty(suite.T(), response.Result().Cookies(), username)
+	}
+}
+
+func (suite *serverSuite) Test_LoginEndpoint_LocksUserAfterFailedLogins() {
+	// given five failed logins in a row
+	api := suite.loginApi()
+	for i := 0; i < 5; i++ {
+		login(api, "alice", "wrong password", "")
+	}
+
+	// when logging in with the correct password
+	response := login(api, "alice", "correct horse", "")
+
+	// then the user is locked
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "Too many failed logins.")
+	assert.Empty(suite.T(), response.Result().Cookies())
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RedirectsToLoginWithoutSession() {
+	// when sending an authorization request without session
+	response := authorize(suite.loginApi(), authorizationQuery())
+
+	// then the user is redirected to the login page, which returns to the authorization request
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "/login", location.Path)
+	returnTo, err := url.Parse(location.Query().Get("return_to"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "/authorize", returnTo.Path)
+	assert.Equal(suite.T(), authorizationQuery(), returnTo.Query())
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_IssuesCodeForSession() {
+	// given a logged in user
+	api := suite.loginApi()
+	cookies := login(api, "alice", "correct horse", "").Result().Cookies()
+
+	// when sending an authorization request with the session cookie
+	request := httptest.NewRequest(http.MethodGet, "/authorize?"+authorizationQuery().Encode(), nil)
+	request.AddCookie(cookies[0])
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+
+	// then the client is redirected with a code
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), location.Query().Get("code"))
+}
+
+func (suite *serverSuite) Test_LogoutEndpoint_EndsSession() {
+	// given a logged in user, who logs out
+	api := suite.loginApi()
+	cookies := login(api, "alice", "correct horse", "").Result().Cookies()
+	request := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	request.AddCookie(cookies[0])
+	api.ServeHTTP(httptest.NewRecorder(), request)
+
+	// when sending an authorization request with the old session cookie
+	request = httptest.NewRequest(http.MethodGet, "/authorize?"+authorizationQuery().Encode(), nil)
+	request.AddCookie(cookies[0])
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+
+	// then the user is redirected to the login page
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	assert.True(suite.T(), strings.HasPrefix(response.Header().Get("Location"), "/login?"))
+}
@@ -0,0 +1,147 @@
+package idp
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// Metadata is the authorization server metadata of RFC 8414 section 2.
+type Metadata struct {
+	Issuer                                    string   `json:"issuer"`
+	AuthorizationEndpoint                     string   `json:"authorization_endpoint"`
+	TokenEndpoint                             string   `json:"token_endpoint"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint"`
+	RevocationEndpoint                        string   `json:"revocation_endpoint"`
+	JwksUri                                   string   `json:"jwks_uri"`
+	ScopesSupported                           []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported                    []string `json:"response_types_supported"`
+	GrantTypesSupported                       []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported             []string `json:"code_challenge_methods_supported"`
+	// AccessTokenSigningAlgValuesSupported lists the algorithms of the keys that verify access tokens.
+	// RFC 8414 registers no parameter for them, so it is an additional parameter of section 2.
+	AccessTokenSigningAlgValuesSupported []string `json:"access_token_signing_alg_values_supported"`
+	// The following fields are only part of the OpenID Provider metadata of OpenID Connect Discovery section 3.
+	UserInfoEndpoint                 string   `json:"userinfo_endpoint,omitempty"`
+	SubjectTypesSupported            []string `json:"subject_types_supported,omitempty"`
+	IdTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	ClaimsSupported                  []string `json:"claims_supported,omitempty"`
+}
+
+// MetadataHandler publishes the authorization server metadata as described in RFC 8414,
+// so that clients can discover the endpoints and capabilities of the server instead of hardcoding them.
+// It is served at /.well-known/oauth-authorization-server.
+//
+// The endpoints are relative to the configured issuer of the server, and never derived from the Host and
+// X-Forwarded-Proto headers of the request, which could be spoofed.
+// The signing algorithms of the verification keys are advertised, and the public keys are published at the jwks_uri.
+// Next to client authentication, the introspection endpoint accepts a bearer access token of the client.
+// The implicit grant and the token response type are only advertised if they are enabled with WithImplicitGrant,
+// the password grant only if it is enabled with WithPasswordGrant.
+func (s *Server) MetadataHandler(w http.ResponseWriter, r *http.Request) {
+	metadata, err := s.metadata()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(metadata)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+}
+
+// OpenIdConfigurationHandler publishes the OpenID Provider metadata as described in OpenID Connect Discovery,
+// which extends the metadata of the MetadataHandler with the UserInfo endpoint, the signing algorithms of ID tokens
+// and the supported claims. It is served at /.well-known/openid-configuration.
+//
+// OpenID Connect Discovery requires RS256 to be among the signing algorithms of ID tokens, so if the server signs
+// with another key, like the HS256 key of WithSigningKey or an ES256 key, it responds with a 500 Internal Server Error
+// and a server_error error. Only the algorithms of asymmetric keys are advertised.
+func (s *Server) OpenIdConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	metadata, err := s.metadata()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	signingKey, err := s.keys.SigningKey()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if signingKey.Algorithm() != AlgorithmRS256 || signingKey.Jwk() == nil {
+		writeError(w, r, NewError(ErrorServerError, "OpenID Connect Discovery requires ID tokens signed with RS256"))
+		return
+	}
+	verificationKeys, err := s.keys.VerificationKeys()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	metadata.UserInfoEndpoint = metadata.Issuer + "/userinfo"
+	metadata.SubjectTypesSupported = []string{"public"}
+	metadata.IdTokenSigningAlgValuesSupported = []string{}
+	for _, key := range verificationKeys {
+		if key.Jwk() != nil && !slices.Contains(metadata.IdTokenSigningAlgValuesSupported, key.Algorithm()) {
+			metadata.IdTokenSigningAlgValuesSupported = append(metadata.IdTokenSigningAlgValuesSupported, key.Algorithm())
+		}
+	}
+	metadata.ClaimsSupported = []string{
+		"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "acr", "at_hash",
+		"preferred_username", "name", "given_name", "family_name", "email", "email_verified",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(metadata)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+}
+
+// metadata returns the metadata of the server.
+func (s *Server) metadata() (Metadata, error) {
+	verificationKeys, err := s.keys.VerificationKeys()
+	if err != nil {
+		return Metadata{}, err
+	}
+	algorithms := []string{}
+	for _, key := range verificationKeys {
+		if !slices.Contains(algorithms, key.Algorithm()) {
+			algorithms = append(algorithms, key.Algorithm())
+		}
+	}
+
+	responseTypes := []string{"code"}
+	grantTypes := []string{"authorization_code", "client_credentials", "refresh_token"}
+	if s.implicitGrant {
+		responseTypes = append(responseTypes, "token")
+		grantTypes = append(grantTypes, "implicit")
+	}
+	if s.passwordGrant {
+		grantTypes = append(grantTypes, "password")
+	}
+
+	return Metadata{
+		Issuer:                            s.issuer,
+		AuthorizationEndpoint:             s.issuer + "/authorize",
+		TokenEndpoint:                     s.issuer + "/token",
+		IntrospectionEndpoint:             s.issuer + "/introspect",
+		RevocationEndpoint:                s.issuer + "/revoke",
+		JwksUri:                           s.issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   s.scopesSupported,
+		ResponseTypesSupported:            responseTypes,
+		GrantTypesSupported:               grantTypes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		IntrospectionEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "bearer"},
+		RevocationEndpointAuthMethodsSupported:    []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:             []string{codeChallengeMethodS256, codeChallengeMethodPlain},
+		AccessTokenSigningAlgValuesSupported:      algorithms,
+	}, nil
+}
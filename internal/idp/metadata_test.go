@@ -0,0 +1,91 @@
+package idp_test
+
+import (
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+)
+
+func (suite *serverSuite) Test_MetadataEndpoint_ReturnsMetadataOfIssuer() {
+	// when requesting the authorization server metadata
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/oauth-authorization-server", nil)
+	response := httptest.NewRecorder()
+	suite.InitIdpApi().ServeHTTP(response, request)
+
+	// then the endpoints are relative to the issuer
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), "application/json", response.Header().Get("Content-Type"))
+	var metadata map[string]interface{}
+	err := json.NewDecoder(response.Body).Decode(&metadata)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "https://idp.example.com", metadata["issuer"])
+	assert.Equal(suite.T(), "https://idp.example.com/authorize", metadata["authorization_endpoint"])
+	assert.Equal(suite.T(), "https://idp.example.com/token", metadata["token_endpoint"])
+	assert.Equal(suite.T(), "https://idp.example.com/introspect", metadata["introspection_endpoint"])
+	assert.Equal(suite.T(), "https://idp.example.com/revoke", metadata["revocation_endpoint"])
+	assert.Equal(suite.T(), "https://idp.example.com/.well-known/jwks.json", metadata["jwks_uri"])
+	assert.Equal(suite.T(), []interface{}{"authorization_code", "client_credentials", "refresh_token"}, metadata["grant_types_supported"])
+	assert.Equal(suite.T(), []interface{}{"code"}, metadata["response_types_supported"])
+	assert.Equal(suite.T(), []interface{}{"client_secret_basic", "client_secret_post", "none"}, metadata["token_endpoint_auth_methods_supported"])
+	assert.Equal(suite.T(), []interface{}{"client_secret_basic", "client_secret_post", "bearer"}, metadata["introspection_endpoint_auth_methods_supported"])
+	assert.Equal(suite.T(), []interface{}{"S256", "plain"}, metadata["code_challenge_methods_supported"])
+	assert.Equal(suite.T(), []interface{}{"HS256"}, metadata["access_token_signing_alg_values_supported"])
+	assert.NotContains(suite.T(), metadata, "scopes_supported")
+}
+
+func (suite *serverSuite) Test_MetadataEndpoint_ReturnsScopesSupported() {
+	// given a server with supported scopes
+	server := suite.newServer(idp.WithIssuer("https://login.example.com/"), idp.WithScopesSupported([]string{"read:example"}))
+
+	// when requesting the metadata
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/oauth-authorization-server", nil)
+	response := httptest.NewRecorder()
+	server.MetadataHandler(response, request)
+
+	// then the scopes are advertised and the endpoints have no double slash
+	var metadata idp.Metadata
+	err := json.NewDecoder(response.Body).Decode(&metadata)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "https://login.example.com", metadata.Issuer)
+	assert.Equal(suite.T(), "https://login.example.com/token", metadata.TokenEndpoint)
+	assert.Equal(suite.T(), []string{"read:example"}, metadata.ScopesSupported)
+}
+
+func (suite *serverSuite) Test_MetadataEndpoint_ReturnsIssuerOfTokens() {
+	// given a server, whose issuer has a trailing slash
+	server := suite.newServer(idp.WithSigningKey(&suite.signingKey), idp.WithClock(suite.clock), idp.WithIssuer("https://login.example.com/"), idp.WithAudience("https://api.example.com"))
+
+	// when requesting the metadata and a token
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/oauth-authorization-server", nil)
+	response := httptest.NewRecorder()
+	server.MetadataHandler(response, request)
+	token := issueToken(suite, server)
+
+	// then the iss claim of the token matches the issuer of the metadata
+	var metadata idp.Metadata
+	err := json.NewDecoder(response.Body).Decode(&metadata)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), metadata.Issuer, decodeSegment(suite, token, 1)["iss"])
+}
+
+func (suite *serverSuite) Test_MetadataEndpoints_UseConfiguredIssuer() {
+	// given a server with an issuer and an RS256 key
+	key, err := idp.GenerateKey(idp.AlgorithmRS256)
+	assert.NoError(suite.T(), err)
+	server := suite.newServer(idp.WithIssuer("https://login.example.com"), idp.WithKey(key))
+
+	for _, handler := range []http.HandlerFunc{server.MetadataHandler, server.OpenIdConfigurationHandler} {
+		// when requesting the metadata with a spoofed host through a proxy
+		request := httptest.NewRequest(http.MethodGet, "http://attacker.example.com/.well-known/oauth-authorization-server", nil)
+		request.Header.Set("X-Forwarded-Proto", "https")
+		response := httptest.NewRecorder()
+		handler(response, request)
+
+		// then the metadata contains the configured issuer instead of the host of the request
+		assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+		assert.Contains(suite.T(), response.Body.String(), `"issuer":"https://login.example.com"`)
+		assert.NotContains(suite.T(), response.Body.String(), "attacker.example.com")
+	}
+}
@@ -0,0 +1,151 @@
+package idp
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/dgrijalva/jwt-go"
+	"hash"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes of OpenID Connect Core section 5.4.
+const (
+	scopeOpenId  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
+const idTokenLifetime = time.Hour
+
+// Authentication describes how the end user of an authorization request was authenticated.
+type Authentication struct {
+	Subject  string
+	AuthTime time.Time
+	Acr      string
+}
+
+// Authenticator authenticates the end user of an authorization request.
+// If the user is not yet authenticated, it writes the response itself, for example a redirect to a login page,
+// and returns false.
+type Authenticator interface {
+	Authenticate(w http.ResponseWriter, r *http.Request) (*Authentication, bool)
+}
+
+// authenticate authenticates the end user of the authorization request with the Authenticator of the server.
+// Without an Authenticator, no end user can be authenticated, so the request is refused with a server_error.
+// Clients that act on their own behalf use the client credentials grant instead.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*Authentication, bool) {
+	if s.authenticator == nil {
+		writeError(w, r, NewError(ErrorServerError, "No authenticator is configured"))
+		return nil, false
+	}
+	return s.authenticator.Authenticate(w, r)
+}
+
+// issueIdToken creates the ID token of OpenID Connect Core section 2 for the authorization code and the access token,
+// which was issued together with it. The token expires after the IdTokenLifetime of the client, or after idTokenLifetime.
+func (s *Server) issueIdToken(client *repository.Client, code *repository.AuthorizationCode, accessToken string) (string, error) {
+	key, err := s.keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := s.clock.Now()
+	claims := jwt.MapClaims{
+		"iss":       s.issuer,
+		"sub":       code.Subject,
+		"aud":       code.ClientId,
+		"iat":       now.Unix(),
+		"exp":       now.Add(lifetimeOf(client.IdTokenLifetime, idTokenLifetime)).Unix(),
+		"auth_time": code.AuthTime.Unix(),
+		"at_hash":   tokenHash(key.Algorithm(), accessToken),
+	}
+	if code.Nonce != "" {
+		claims["nonce"] = code.Nonce
+	}
+	if code.Acr != "" {
+		claims["acr"] = code.Acr
+	}
+	return s.signToken("JWT", claims)
+}
+
+// tokenHash returns the at_hash of the token as described in OpenID Connect Core section 3.1.3.6,
+// which is the left half of the hash of the token, using the hash function of the signing algorithm.
+func tokenHash(algorithm string, token string) string {
+	var h hash.Hash
+	if algorithm == AlgorithmEdDSA {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(token))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// UserInfoHandler returns the claims of the end user of an access token as described in OpenID Connect Core section 5.3.
+// The access token must be sent in a Bearer Authorization header and must have the openid scope.
+// The sub claim is always returned, the profile claims for the profile scope and the email claims for the email scope.
+//
+// If the access token is missing, invalid or inactive, it responds with a 401 Unauthorized status and an invalid_token error.
+// If the access token has no openid scope, it responds with a 403 Forbidden status and an insufficient_scope error.
+func (s *Server) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		writeError(w, r, NewError(ErrorInvalidToken, "Missing access token"))
+		return
+	}
+
+	claims, active := s.introspect(token)
+	if !active {
+		writeError(w, r, NewError(ErrorInvalidToken, "Invalid access token"))
+		return
+	}
+	scope, _ := claims["scope"].(string)
+	scopes := strings.Fields(scope)
+	if !slices.Contains(scopes, scopeOpenId) {
+		writeError(w, r, NewError(ErrorInsufficientScope, "The access token has no openid scope"))
+		return
+	}
+
+	subject, _ := claims["sub"].(string)
+	response := map[string]interface{}{"sub": subject}
+	// The subject of a token, which the client obtained for itself, is the client id and not a user.
+	var user *repository.User
+	if !isClientToken(claims) {
+		user, _ = s.userRepository.GetUser(subject)
+	}
+	if user != nil {
+		if slices.Contains(scopes, scopeProfile) {
+			setClaim(response, "preferred_username", user.Username)
+			setClaim(response, "name", user.Name)
+			setClaim(response, "given_name", user.GivenName)
+			setClaim(response, "family_name", user.FamilyName)
+		}
+		if slices.Contains(scopes, scopeEmail) && user.Email != "" {
+			response["email"] = user.Email
+			response["email_verified"] = user.EmailVerified
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+}
+
+// setClaim sets the claim, unless its value is empty.
+func setClaim(claims map[string]interface{}, name string, value string) {
+	if value != "" {
+		claims[name] = value
+	}
+}
@@ -0,0 +1,320 @@
+package idp_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IdToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// testAuthenticator authenticates every authorization request as alice, unless login is set,
+// in which case it redirects to the login page.
+type testAuthenticator struct {
+	login bool
+}
+
+func (a testAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*idp.Authentication, bool) {
+	if a.login {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return nil, false
+	}
+	return &idp.Authentication{Subject: "alice", AuthTime: TestClock{}.Now().Add(-time.Minute), Acr: "1"}, true
+}
+
+func (suite *serverSuite) oidcApi(opts ...idp.ServerOption) http.Handler {
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:     "webapp",
+		ClientSecret: "webapp_secret",
+		RedirectUris: []string{"http://localhost:3000/callback"},
+		Scopes:       []string{"openid", "profile", "email", "read:example"},
+	})
+	assert.NoError(suite.T(), err)
+
+	users := repository.NewSimpleUserRepository()
+	err = users.SaveUser(repository.User{
+		Subject:       "alice",
+		Username:      "alice",
+		Name:          "Alice Example",
+		GivenName:     "Alice",
+		FamilyName:    "Example",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+	})
+	assert.NoError(suite.T(), err)
+
+	opts = append([]idp.ServerOption{
+		idp.WithSigningKey(&suite.signingKey),
+		idp.WithClock(suite.clock),
+		idp.WithIssuer("https://idp.example.com"),
+		idp.WithAudience("https://api.example.com"),
+		idp.WithUserRepository(users),
+		idp.WithAuthenticator(testAuthenticator{}),
+	}, opts...)
+	server := suite.newServer(opts...)
+	router := mux.NewRouter()
+	router.HandleFunc("/authorize", server.AuthorizeHandler)
+	router.HandleFunc("/token", server.TokenHandler)
+	router.HandleFunc("/userinfo", server.UserInfoHandler)
+	router.HandleFunc("/introspect", server.IntrospectHandler)
+	router.HandleFunc("/.well-known/openid-configuration", server.OpenIdConfigurationHandler)
+	return router
+}
+
+// signIn runs the authorization code flow of the webapp client for the scope and returns the token response.
+func (suite *serverSuite) signIn(api http.Handler, scope string) oidcTokenResponse {
+	query := authorizationQuery()
+	query.Set("client_id", "webapp")
+	query.Set("scope", scope)
+	query.Set("nonce", "n-0S6_WzA2Mj")
+	location, _ := url.Parse(authorize(api, query).Header().Get("Location"))
+
+	requestBody := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {"http://localhost:3000/callback"},
+		"code_verifier": {codeVerifier},
+	}
+	request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(requestBody.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth("webapp", "webapp_secret")
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+
+	var tokenResponse oidcTokenResponse
+	err := json.NewDecoder(response.Body).Decode(&tokenResponse)
+	assert.NoError(suite.T(), err)
+	return tokenResponse
+}
+
+func userInfo(api http.Handler, accessToken string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+	if accessToken != "" {
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_ReturnsIdTokenForOpenIdScope() {
+	// when signing in with the openid scope
+	tokenResponse := suite.signIn(suite.oidcApi(), "openid profile")
+
+	// then an ID token for the authenticated user is returned
+	assert.NotEmpty(suite.T(), tokenResponse.IdToken)
+	assert.Equal(suite.T(), "JWT", decodeSegment(suite, tokenResponse.IdToken, 0)["typ"])
+	claims := decodeSegment(suite, tokenResponse.IdToken, 1)
+	assert.Equal(suite.T(), "https://idp.example.com", claims["iss"])
+	assert.Equal(suite.T(), "alice", claims["sub"])
+	assert.Equal(suite.T(), "webapp", claims["aud"])
+	assert.Equal(suite.T(), "n-0S6_WzA2Mj", claims["nonce"])
+	assert.Equal(suite.T(), "1", claims["acr"])
+	assert.Equal(suite.T(), float64(suite.clock.Now().Add(-time.Minute).Unix()), claims["auth_time"])
+	assert.Equal(suite.T(), float64(suite.clock.Now().Unix()), claims["iat"])
+	hash := sha256.Sum256([]byte(tokenResponse.AccessToken))
+	assert.Equal(suite.T(), base64.RawURLEncoding.EncodeToString(hash[:16]), claims["at_hash"])
+
+	// and the access token is issued for the user
+	assert.Equal(suite.T(), "alice", decodeSegment(suite, tokenResponse.AccessToken, 1)["sub"])
+}
+
+func (suite *serverSuite) Test_IdToken_IsNotAcceptedAsAccessToken() {
+	// given an ID token, which is signed with the same key as access tokens
+	api := suite.oidcApi()
+	tokenResponse := suite.signIn(api, "openid profile")
+	assert.Equal(suite.T(), "alice", decodeSegment(suite, tokenResponse.IdToken, 1)["sub"])
+
+	// when introspecting the ID token
+	request := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(url.Values{"token": {tokenResponse.IdToken}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth("1234567890", "client_secret")
+	introspection := httptest.NewRecorder()
+	api.ServeHTTP(introspection, request)
+
+	// then it is reported as inactive
+	assert.Equal(suite.T(), http.StatusOK, introspection.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"active\":false}\n", introspection.Body.String())
+
+	// and the userinfo endpoint rejects it as bearer token
+	response := userInfo(api, tokenResponse.IdToken)
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "invalid_token")
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_ReturnsNoIdTokenWithoutOpenIdScope() {
+	// when signing in without the openid scope
+	tokenResponse := suite.signIn(suite.oidcApi(), "read:example")
+
+	// then no ID token is returned
+	assert.Empty(suite.T(), tokenResponse.IdToken)
+	assert.NotEmpty(suite.T(), tokenResponse.AccessToken)
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_LetsAuthenticatorLogInUser() {
+	// given an authenticator, which has not yet authenticated the user
+	api := suite.oidcApi(idp.WithAuthenticator(testAuthenticator{login: true}))
+
+	// when sending an authorization request
+	query := authorizationQuery()
+	query.Set("client_id", "webapp")
+	response := authorize(api, query)
+
+	// then the authenticator redirects to the login page instead of issuing a code
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	assert.Equal(suite.T(), "/login", response.Header().Get("Location"))
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RefusesRequestWithoutAuthenticator() {
+	// given a server without an authenticator
+	api := suite.oidcApi(idp.WithAuthenticator(nil))
+
+	// when sending an authorization request for an ID token
+	query := authorizationQuery()
+	query.Set("client_id", "webapp")
+	query.Set("scope", "openid")
+	response := authorize(api, query)
+
+	// then the response should be 500 Internal Server Error without a code
+	assert.Equal(suite.T(), http.StatusInternalServerError, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "server_error")
+	assert.Empty(suite.T(), response.Header().Get("Location"))
+}
+
+func (suite *serverSuite) Test_UserInfoEndpoint_ReturnsClaimsOfScopes() {
+	// given an access token for the profile and email scopes
+	api := suite.oidcApi()
+	tokenResponse := suite.signIn(api, "openid profile email")
+
+	// when requesting the user info
+	response := userInfo(api, tokenResponse.AccessToken)
+
+	// then the profile and email claims of the user are returned
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"email\":\"alice@example.com\",\"email_verified\":true,\"family_name\":\"Example\",\"given_name\":\"Alice\",\"name\":\"Alice Example\",\"preferred_username\":\"alice\",\"sub\":\"alice\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_UserInfoEndpoint_ReturnsOnlySubjectForOpenIdScope() {
+	// given an access token for the openid scope only
+	api := suite.oidcApi()
+	tokenResponse := suite.signIn(api, "openid")
+
+	// when requesting the user info
+	response := userInfo(api, tokenResponse.AccessToken)
+
+	// then only the subject is returned
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"sub\":\"alice\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_UserInfoEndpoint_ReturnsOnlySubjectForClientToken() {
+	// given a user, whose subject is the id of the client webapp, and an access token the client obtained for itself
+	users := repository.NewSimpleUserRepository()
+	err := users.SaveUser(repository.User{Subject: "webapp", Username: "mallory", Email: "mallory@example.com"})
+	assert.NoError(suite.T(), err)
+	api := suite.oidcApi(idp.WithUserRepository(users))
+	request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}, "scope": {"openid profile email"}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth("webapp", "webapp_secret")
+	tokenResponse := httptest.NewRecorder()
+	api.ServeHTTP(tokenResponse, request)
+	var token oidcTokenResponse
+	err = json.NewDecoder(tokenResponse.Body).Decode(&token)
+	assert.NoError(suite.T(), err)
+
+	// when requesting the user info with the token of the client
+	response := userInfo(api, token.AccessToken)
+
+	// then no claims of the user are returned
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"sub\":\"webapp\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_UserInfoEndpoint_ReturnsForbiddenWithoutOpenIdScope() {
+	// given an access token without the openid scope
+	api := suite.oidcApi()
+	tokenResponse := suite.signIn(api, "read:example")
+
+	// when requesting the user info
+	response := userInfo(api, tokenResponse.AccessToken)
+
+	// then the response should be 403 Forbidden with an insufficient_scope error
+	assert.Equal(suite.T(), http.StatusForbidden, response.Result().StatusCode)
+	assert.Equal(suite.T(), `Bearer realm="open-idp", error="insufficient_scope"`, response.Header().Get("WWW-Authenticate"))
+}
+
+func (suite *serverSuite) Test_UserInfoEndpoint_ReturnsUnauthorizedForInvalidToken() {
+	// when requesting the user info without and with an invalid access token
+	api := suite.oidcApi()
+	for _, token := range []string{"", "invalid"} {
+		response := userInfo(api, token)
+
+		// then the response should be 401 Unauthorized with an invalid_token error
+		assert.Equal(suite.T(), http.StatusUnauthorized, response.Result().StatusCode)
+		assert.Equal(suite.T(), `Bearer realm="open-idp", error="invalid_token"`, response.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func (suite *serverSuite) Test_OpenIdConfigurationEndpoint_ReturnsProviderMetadata() {
+	// given a server with an RS256 key
+	key, err := idp.GenerateKey(idp.AlgorithmRS256)
+	assert.NoError(suite.T(), err)
+	api := suite.oidcApi(idp.WithKey(key))
+
+	// when requesting the OpenID Provider metadata
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil)
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+
+	// then the UserInfo endpoint and the signing algorithms of ID tokens are included
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	var metadata idp.Metadata
+	err = json.NewDecoder(response.Body).Decode(&metadata)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "https://idp.example.com", metadata.Issuer)
+	assert.Equal(suite.T(), "https://idp.example.com/userinfo", metadata.UserInfoEndpoint)
+	assert.Equal(suite.T(), []string{"public"}, metadata.SubjectTypesSupported)
+	assert.Equal(suite.T(), []string{"RS256"}, metadata.IdTokenSigningAlgValuesSupported)
+	assert.Contains(suite.T(), metadata.ClaimsSupported, "email")
+}
+
+func (suite *serverSuite) Test_OpenIdConfigurationEndpoint_RefusesSymmetricSigningKey() {
+	// when requesting the OpenID Provider metadata of a server that signs with an HS256 key
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil)
+	response := httptest.NewRecorder()
+	suite.oidcApi().ServeHTTP(response, request)
+
+	// then the response should be 500 Internal Server Error, because clients can not verify its ID tokens
+	assert.Equal(suite.T(), http.StatusInternalServerError, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "server_error")
+}
+
+func (suite *serverSuite) Test_OpenIdConfigurationEndpoint_RefusesSigningKeyWithoutRS256() {
+	// given a server with an ES256 key
+	key, err := idp.GenerateKey(idp.AlgorithmES256)
+	assert.NoError(suite.T(), err)
+	api := suite.oidcApi(idp.WithKey(key))
+
+	// when requesting the OpenID Provider metadata
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil)
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+
+	// then the response should be 500 Internal Server Error, because OpenID Connect Discovery requires RS256
+	assert.Equal(suite.T(), http.StatusInternalServerError, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "server_error")
+}
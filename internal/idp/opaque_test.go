@@ -0,0 +1,91 @@
+package idp_test
+
+import (
+	"bytes"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func (suite *serverSuite) issueOpaqueToken(api http.Handler) string {
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:     "partner",
+		ClientSecret: "partner_secret",
+		Scopes:       []string{"read:example"},
+		TokenFormat:  repository.TokenFormatOpaque,
+	})
+	assert.NoError(suite.T(), err)
+
+	requestBody := `{"client_id":"partner","client_secret":"partner_secret","grant_type":"client_credentials"}`
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/token", bytes.NewBufferString(requestBody)))
+	return suite.accessToken(response)
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_IssuesOpaqueTokenForClientWithOpaqueFormat() {
+	// when a client with the opaque token format requests a token
+	token := suite.issueOpaqueToken(suite.InitIdpApi())
+
+	// then the token is an opaque handle that does not reveal any claims
+	assert.Len(suite.T(), token, 43)
+	assert.NotContains(suite.T(), token, ".")
+}
+
+func (suite *serverSuite) Test_IntrospectEndpoint_ResolvesOpaqueToken() {
+	// given an opaque token
+	server := suite.server()
+	token := suite.issueOpaqueToken(http.HandlerFunc(server.TokenHandler))
+
+	// when introspecting the token
+	introspection := introspect(suite, server, token)
+
+	// then its claims are returned from the store
+	assert.Equal(suite.T(), true, introspection["active"])
+	assert.Equal(suite.T(), "partner", introspection["client_id"])
+	assert.Equal(suite.T(), "partner", introspection["sub"])
+	assert.Equal(suite.T(), "read:example", introspection["scope"])
+	assert.Equal(suite.T(), "https://idp.example.com", introspection["iss"])
+	assert.Equal(suite.T(), float64(suite.clock.Now().Add(time.Hour).Unix()), introspection["exp"])
+}
+
+func (suite *serverSuite) Test_IntrospectEndpoint_ReturnsInactiveForUnknownOpaqueToken() {
+	// when introspecting an opaque token that was never issued
+	introspection := introspect(suite, suite.server(), "unknownunknownunknownunknownunknownunknownu")
+
+	// then the token is inactive
+	assert.Equal(suite.T(), map[string]interface{}{"active": false}, introspection)
+}
+
+func (suite *serverSuite) Test_IntrospectEndpoint_ReturnsInactiveForExpiredOpaqueToken() {
+	// given an opaque token
+	clock := &mutableClock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	server := suite.newServer(idp.WithIssuer("https://idp.example.com"), idp.WithAudience("https://api.example.com"), idp.WithSigningKey(&suite.signingKey), idp.WithClock(clock))
+	token := suite.issueOpaqueToken(http.HandlerFunc(server.TokenHandler))
+
+	// when introspecting it after it expired
+	clock.now = clock.now.Add(time.Hour + time.Second)
+	introspection := introspect(suite, server, token)
+
+	// then the token is inactive
+	assert.Equal(suite.T(), map[string]interface{}{"active": false}, introspection)
+}
+
+func (suite *serverSuite) Test_RevokeEndpoint_RevokesOpaqueToken() {
+	// given an opaque token
+	server := suite.server()
+	token := suite.issueOpaqueToken(http.HandlerFunc(server.TokenHandler))
+
+	// when revoking the token
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(`{"token":"`+token+`"}`))
+	request.SetBasicAuth("partner", "partner_secret")
+	server.RevokeHandler(response, request)
+
+	// then the token is reported as inactive
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), map[string]interface{}{"active": false}, introspect(suite, server, token))
+}
@@ -0,0 +1,8 @@
+package idp
+
+import "github.com/daschaa/open-idp/internal/secret"
+
+// HashPassword hashes the password of a user with argon2id for the PasswordHash of the UserRepository.
+func HashPassword(password string) (string, error) {
+	return secret.Hash(password, secret.PasswordParams)
+}
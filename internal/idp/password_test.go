@@ -0,0 +1,103 @@
+package idp_test
+
+import (
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+func passwordGrant(api http.Handler, clientId string, clientSecret string, username string, password string) *httptest.ResponseRecorder {
+	form := url.Values{"grant_type": {"password"}, "username": {username}, "password": {password}}
+	request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(clientId, clientSecret)
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_IssuesTokensForPasswordGrant() {
+	// when the client cli exchanges the username and password of alice
+	response := passwordGrant(suite.loginApi(idp.WithPasswordGrant()), "cli", "cli_secret", "alice", "correct horse")
+
+	// then an access token for alice and a refresh token are returned
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	var body map[string]interface{}
+	err := json.NewDecoder(response.Body).Decode(&body)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "read:example", body["scope"])
+	assert.NotEmpty(suite.T(), body["refresh_token"])
+	claims := decodeSegment(suite, body["access_token"].(string), 1)
+	assert.Equal(suite.T(), "alice", claims["sub"])
+	assert.Equal(suite.T(), "cli", claims["client_id"])
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsPasswordGrantOfOtherClients() {
+	// when a client, which is not allowed to use the password grant, sends valid user credentials
+	response := passwordGrant(suite.loginApi(idp.WithPasswordGrant()), "1234567890", "client_secret", "alice", "correct horse")
+
+	// then the response should be 400 Bad Request with an unauthorized_client error
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"unauthorized_client\",\"error_description\":\"The client is not allowed to use the password grant\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsWrongPassword() {
+	// when sending a wrong password or an unknown username
+	api := suite.loginApi(idp.WithPasswordGrant())
+	for _, username := range []string{"alice", "mallory"} {
+		response := passwordGrant(api, "cli", "cli_secret", username, "wrong password")
+
+		// then the response should be 400 Bad Request with an invalid_grant error
+		assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode, username)
+		assert.Equal(suite.T(), "{\"error\":\"invalid_grant\",\"error_description\":\"Invalid username or password\"}\n", response.Body.String(), username)
+	}
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_SharesLockoutWithLogin() {
+	// given five failed logins in a row at the login page
+	api := suite.loginApi(idp.WithPasswordGrant())
+	for i := 0; i < 5; i++ {
+		login(api, "alice", "wrong password", "")
+	}
+
+	// when using the correct password with the password grant
+	response := passwordGrant(api, "cli", "cli_secret", "alice", "correct horse")
+
+	// then the user is locked
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_grant\",\"error_description\":\"Too many failed logins\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsPasswordGrantIfDisabled() {
+	// when the client cli sends valid user credentials to a server without the password grant
+	response := passwordGrant(suite.loginApi(), "cli", "cli_secret", "alice", "correct horse")
+
+	// then the response should be 400 Bad Request with an unsupported_grant_type error
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "unsupported_grant_type")
+}
+
+func (suite *serverSuite) Test_MetadataEndpoint_AdvertisesPasswordGrantIfEnabled() {
+	for _, enabled := range []bool{false, true} {
+		// given a server with or without the password grant
+		var opts []idp.ServerOption
+		if enabled {
+			opts = append(opts, idp.WithPasswordGrant())
+		}
+
+		// when requesting the metadata
+		response := httptest.NewRecorder()
+		suite.loginApi(opts...).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/.well-known/oauth-authorization-server", nil))
+		var metadata map[string]interface{}
+		err := json.NewDecoder(response.Body).Decode(&metadata)
+		assert.NoError(suite.T(), err)
+
+		// then the password grant is only advertised if enabled
+		assert.Equal(suite.T(), enabled, assert.ObjectsAreEqual(
+			[]interface{}{"authorization_code", "client_credentials", "refresh_token", "password"}, metadata["grant_types_supported"]))
+	}
+}
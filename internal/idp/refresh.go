@@ -0,0 +1,88 @@
+package idp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/thanhpk/randstr"
+	"strings"
+	"time"
+)
+
+const refreshTokenLifetime = 30 * 24 * time.Hour
+
+// issueRefreshToken starts a new refresh token family for the grant and returns its first refresh token.
+// The family expires after the RefreshTokenLifetime of the client, or after refreshTokenLifetime.
+// Clients that may not use the refresh token grant receive no refresh token, which is returned as an empty string.
+func (s *Server) issueRefreshToken(client *repository.Client, subject string, scope string, audiences []string) (string, error) {
+	if !client.AllowsGrantType(repository.GrantTypeRefreshToken) {
+		return "", nil
+	}
+
+	familyId := randstr.String(16)
+	token := newRefreshToken(familyId)
+
+	err := s.refreshTokenRepository.SaveRefreshTokenFamily(repository.RefreshTokenFamily{
+		FamilyId:  familyId,
+		TokenHash: hashToken(token),
+		ClientId:  client.ClientId,
+		Subject:   subject,
+		Scope:     scope,
+		Audiences: audiences,
+		ExpiresAt: s.clock.Now().Add(lifetimeOf(client.RefreshTokenLifetime, refreshTokenLifetime)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// refreshTokenFamily returns the family of the current refresh token of the client, without redeeming the token.
+// Presenting a refresh token that was already rotated revokes the whole family,
+// because either the legitimate client or an attacker holds a stolen token.
+func (s *Server) refreshTokenFamily(clientId string, token string) (*repository.RefreshTokenFamily, bool) {
+	familyId, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+
+	family, err := s.refreshTokenRepository.GetRefreshTokenFamily(familyId)
+	if err != nil || family.Revoked || family.ClientId != clientId || !s.clock.Now().Before(family.ExpiresAt) {
+		return nil, false
+	}
+
+	if hashToken(token) != family.TokenHash {
+		_ = s.refreshTokenRepository.RevokeRefreshTokenFamily(familyId)
+		return nil, false
+	}
+	return family, true
+}
+
+// rotateRefreshToken redeems the refresh token of the family and returns the next refresh token.
+// It must only be called once the request is known to succeed, because the redeemed token can not be used again.
+// If the token was redeemed concurrently, the whole family is revoked like in refreshTokenFamily.
+func (s *Server) rotateRefreshToken(family *repository.RefreshTokenFamily, token string) (string, bool) {
+	next := newRefreshToken(family.FamilyId)
+	err := s.refreshTokenRepository.RotateRefreshToken(family.FamilyId, hashToken(token), hashToken(next))
+	if errors.Is(err, repository.RefreshTokenReused{}) {
+		_ = s.refreshTokenRepository.RevokeRefreshTokenFamily(family.FamilyId)
+		return "", false
+	}
+	if err != nil {
+		return "", false
+	}
+	return next, true
+}
+
+// newRefreshToken creates a refresh token that carries its family id as prefix, so that the family can be looked up
+// without storing every token that was ever issued.
+func newRefreshToken(familyId string) string {
+	return familyId + "." + randstr.String(32)
+}
+
+// hashToken returns the SHA-256 hash of a refresh token or opaque access token, under which it is stored.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
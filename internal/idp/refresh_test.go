@@ -0,0 +1,126 @@
+package idp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+func refresh(api http.Handler, refreshToken string) *httptest.ResponseRecorder {
+	requestBody, _ := json.Marshal(map[string]string{
+		"grant_type":    "refresh_token",
+		"client_id":     "1234567890",
+		"client_secret": "client_secret",
+		"refresh_token": refreshToken,
+	})
+	request := httptest.NewRequest(http.MethodPost, "/token", bytes.NewBuffer(requestBody))
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *serverSuite) obtainRefreshToken(api http.Handler) string {
+	location, _ := url.Parse(authorize(api, authorizationQuery()).Header().Get("Location"))
+	response := exchangeCode(api, location.Query().Get("code"), codeVerifier)
+	var jsonTokenResponse tokenResponse
+	err := json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), jsonTokenResponse.RefreshToken)
+	return jsonTokenResponse.RefreshToken
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RotatesRefreshToken() {
+	// given a refresh token from the authorization code grant
+	api := suite.InitIdpApi()
+	refreshToken := suite.obtainRefreshToken(api)
+
+	// when using the refresh token
+	response := refresh(api, refreshToken)
+
+	// then a new access token and a new refresh token are returned, which must not be cached
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), "no-store", response.Header().Get("Cache-Control"))
+	var jsonTokenResponse tokenResponse
+	err := json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), jsonTokenResponse.AccessToken)
+	assert.NotEmpty(suite.T(), jsonTokenResponse.RefreshToken)
+	assert.NotEqual(suite.T(), refreshToken, jsonTokenResponse.RefreshToken)
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_IssuesNoRefreshTokenToClientWithoutRefreshTokenGrant() {
+	// given a client that is restricted to the authorization code grant
+	_, err := suite.clientRepository.UpdateClient(repository.Client{
+		ClientId:     "1234567890",
+		RedirectUris: []string{"http://localhost:3000/callback"},
+		GrantTypes:   []string{repository.GrantTypeAuthorizationCode},
+	})
+	assert.NoError(suite.T(), err)
+	api := suite.InitIdpApi()
+
+	// when exchanging an authorization code
+	location, _ := url.Parse(authorize(api, authorizationQuery()).Header().Get("Location"))
+	response := exchangeCode(api, location.Query().Get("code"), codeVerifier)
+
+	// then an access token is returned, but no refresh token
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	var jsonTokenResponse tokenResponse
+	err = json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), jsonTokenResponse.AccessToken)
+	assert.Empty(suite.T(), jsonTokenResponse.RefreshToken)
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RevokesRefreshTokenFamilyOnReuse() {
+	// given a refresh token that was already rotated
+	api := suite.InitIdpApi()
+	refreshToken := suite.obtainRefreshToken(api)
+	var rotated tokenResponse
+	err := json.NewDecoder(refresh(api, refreshToken).Body).Decode(&rotated)
+	assert.NoError(suite.T(), err)
+
+	// when replaying the old refresh token
+	response := refresh(api, refreshToken)
+
+	// then the request is rejected and the rotated refresh token is revoked as well
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_grant\",\"error_description\":\"Invalid refresh token\"}\n", response.Body.String())
+	assert.Equal(suite.T(), http.StatusBadRequest, refresh(api, rotated.RefreshToken).Result().StatusCode)
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_ReturnsBadRequestIfRefreshTokenUnknown() {
+	// when using an unknown refresh token
+	response := refresh(suite.InitIdpApi(), "unknown.refresh-token")
+
+	// then the response should be 400 Bad Request
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_KeepsRefreshTokenOfRejectedRefresh() {
+	// given a refresh token for the read scope
+	api := suite.InitIdpApi()
+	refreshToken := suite.obtainRefreshToken(api)
+
+	// when refreshing with a scope or a resource that was not originally granted
+	for parameter, value := range map[string]string{"scope": "write:example", "resource": "https://billing.example.com"} {
+		requestBody, _ := json.Marshal(map[string]string{
+			"grant_type":    "refresh_token",
+			"client_id":     "1234567890",
+			"client_secret": "client_secret",
+			"refresh_token": refreshToken,
+			parameter:       value,
+		})
+		response := httptest.NewRecorder()
+		api.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/token", bytes.NewBuffer(requestBody)))
+		assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode, parameter)
+	}
+
+	// then the refresh token is still valid and its family is not revoked
+	response := refresh(api, refreshToken)
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "refresh_token")
+}
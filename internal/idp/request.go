@@ -0,0 +1,181 @@
+package idp
+
+import (
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/repository"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// repeatableParameters may occur more than once in a form-encoded body.
+var repeatableParameters = []string{"resource"}
+
+var (
+	errInvalidBody                  = NewError(ErrorInvalidRequest, "Invalid body")
+	errRepeatedParameter            = NewError(ErrorInvalidRequest, "Parameters must not be repeated")
+	errMultipleAuthenticationMethod = NewError(ErrorInvalidRequest, "Multiple client authentication methods")
+)
+
+// parseTokenRequest decodes a token request, which may either be sent as application/x-www-form-urlencoded body
+// as required by RFC 6749, or as JSON body.
+//
+// The client may authenticate with its credentials in the body (client_secret_post)
+// or in an HTTP Basic Authorization header (client_secret_basic), but not with both at the same time.
+func parseTokenRequest(r *http.Request) (tokenRequest, error) {
+	request := tokenRequest{}
+	err := decodeBody(r, &request, func(form url.Values) {
+		request = tokenRequest{
+			ClientId:     form.Get("client_id"),
+			ClientSecret: form.Get("client_secret"),
+			GrantType:    form.Get("grant_type"),
+			Code:         form.Get("code"),
+			RedirectUri:  form.Get("redirect_uri"),
+			CodeVerifier: form.Get("code_verifier"),
+			RefreshToken: form.Get("refresh_token"),
+			Username:     form.Get("username"),
+			Password:     form.Get("password"),
+			Scope:        form.Get("scope"),
+			Resource:     form["resource"],
+		}
+	})
+	if err != nil {
+		return request, err
+	}
+
+	request.ClientId, request.ClientSecret, request.AuthMethod, err = clientCredentials(r, request.ClientId, request.ClientSecret)
+	return request, err
+}
+
+// parseIntrospectRequest decodes an introspection request in the same formats as a token request.
+// The caller may authenticate like at the token endpoint, or with a bearer token in the Authorization header.
+func parseIntrospectRequest(r *http.Request) (introspectRequest, error) {
+	request := introspectRequest{}
+	err := decodeBody(r, &request, func(form url.Values) {
+		request = introspectRequest{
+			TokenTypeHint: form.Get("token_type_hint"),
+			ClientId:      form.Get("client_id"),
+			ClientSecret:  form.Get("client_secret"),
+		}
+		if form.Has("token") {
+			token := form.Get("token")
+			request.Token = &token
+		}
+	})
+	if err != nil {
+		return request, err
+	}
+	if request.Token == nil {
+		return request, errInvalidBody
+	}
+
+	if bearerToken, ok := bearerToken(r); ok {
+		if request.ClientId != "" || request.ClientSecret != "" {
+			return request, errMultipleAuthenticationMethod
+		}
+		request.BearerToken = bearerToken
+		return request, nil
+	}
+
+	request.ClientId, request.ClientSecret, request.AuthMethod, err = clientCredentials(r, request.ClientId, request.ClientSecret)
+	return request, err
+}
+
+// parseRevokeRequest decodes a revocation request in the same formats as a token request.
+func parseRevokeRequest(r *http.Request) (revokeRequest, error) {
+	request := revokeRequest{}
+	err := decodeBody(r, &request, func(form url.Values) {
+		request = revokeRequest{
+			TokenTypeHint: form.Get("token_type_hint"),
+			ClientId:      form.Get("client_id"),
+			ClientSecret:  form.Get("client_secret"),
+		}
+		if form.Has("token") {
+			token := form.Get("token")
+			request.Token = &token
+		}
+	})
+	if err != nil {
+		return request, err
+	}
+	if request.Token == nil {
+		return request, errInvalidBody
+	}
+
+	request.ClientId, request.ClientSecret, request.AuthMethod, err = clientCredentials(r, request.ClientId, request.ClientSecret)
+	return request, err
+}
+
+// decodeBody decodes a form-encoded body with the fromForm function, and any other body as JSON into the request.
+// Form parameters must not be repeated, except for the repeatableParameters.
+func decodeBody(r *http.Request, request interface{}, fromForm func(form url.Values)) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			return errInvalidBody
+		}
+		return nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return errInvalidBody
+	}
+	for name, values := range r.PostForm {
+		if len(values) > 1 && !slices.Contains(repeatableParameters, name) {
+			return errRepeatedParameter
+		}
+	}
+	fromForm(r.PostForm)
+	return nil
+}
+
+// clientCredentials returns the client credentials of the HTTP Basic Authorization header, if present,
+// and otherwise the credentials of the body, together with the authentication method the client used.
+// A request must not use both. Requests without secret use the authentication method none of public clients.
+func clientCredentials(r *http.Request, clientId string, clientSecret string) (string, string, string, error) {
+	if _, _, ok := r.BasicAuth(); !ok {
+		return clientId, clientSecret, clientAuthMethod(clientSecret, repository.AuthMethodClientSecretPost), nil
+	}
+
+	basicClientId, basicClientSecret, err := basicAuthCredentials(r)
+	if err != nil {
+		return "", "", "", errInvalidBody
+	}
+	if clientSecret != "" || (clientId != "" && clientId != basicClientId) {
+		return "", "", "", errMultipleAuthenticationMethod
+	}
+	return basicClientId, basicClientSecret, clientAuthMethod(basicClientSecret, repository.AuthMethodClientSecretBasic), nil
+}
+
+func clientAuthMethod(clientSecret string, authMethod string) string {
+	if clientSecret == "" {
+		return repository.AuthMethodNone
+	}
+	return authMethod
+}
+
+// bearerToken returns the token of a Bearer Authorization header as described in RFC 6750 section 2.1.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// basicAuthCredentials returns the client credentials of the Authorization header.
+// RFC 6749 section 2.3.1 requires the client id and secret to be form-urlencoded before they are base64 encoded.
+func basicAuthCredentials(r *http.Request) (string, string, error) {
+	username, password, _ := r.BasicAuth()
+	clientId, err := url.QueryUnescape(username)
+	if err != nil {
+		return "", "", err
+	}
+	clientSecret, err := url.QueryUnescape(password)
+	if err != nil {
+		return "", "", err
+	}
+	return clientId, clientSecret, nil
+}
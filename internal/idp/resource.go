@@ -0,0 +1,75 @@
+package idp
+
+import (
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"net/url"
+	"slices"
+)
+
+// resourceList holds the resource parameters of a request. RFC 8707 allows the parameter to be repeated,
+// so a JSON body may contain either a single resource or an array of resources.
+type resourceList []string
+
+func (l *resourceList) UnmarshalJSON(data []byte) error {
+	var resource string
+	if err := json.Unmarshal(data, &resource); err == nil {
+		*l = resourceList{resource}
+		return nil
+	}
+	var resources []string
+	if err := json.Unmarshal(data, &resources); err != nil {
+		return err
+	}
+	*l = resources
+	return nil
+}
+
+// grantAudience returns the audiences a token is issued for, given the requested resources of RFC 8707
+// and the audiences the client or the original grant is allowed to use.
+// Without a requested resource, the token is issued for all allowed audiences.
+// If a resource is not an absolute URI without fragment, or is not allowed, it returns an invalid_target error.
+func grantAudience(requested []string, allowed []string) ([]string, error) {
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+
+	var granted []string
+	for _, resource := range requested {
+		uri, err := url.Parse(resource)
+		if err != nil || !uri.IsAbs() || uri.Fragment != "" {
+			return nil, NewError(ErrorInvalidTarget, "The resource must be an absolute URI without fragment")
+		}
+		if !slices.Contains(allowed, resource) {
+			return nil, NewError(ErrorInvalidTarget, "The requested resource is not allowed")
+		}
+		if !slices.Contains(granted, resource) {
+			granted = append(granted, resource)
+		}
+	}
+	return granted, nil
+}
+
+// audienceClaim returns the aud claim for the audiences, which is a single string for one audience
+// and an array for multiple audiences as described in RFC 7519 section 4.1.3.
+func audienceClaim(audiences []string) interface{} {
+	if len(audiences) == 1 {
+		return audiences[0]
+	}
+	return audiences
+}
+
+// hasAudience reports whether the aud claim of the token, which may be a string or an array, contains the audience.
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package idp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+func (suite *serverSuite) saveAudienceClient() {
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:     "audience",
+		ClientSecret: "audience_secret",
+		RedirectUris: []string{"http://localhost:3000/callback"},
+		Audiences:    []string{"https://api.example.com", "https://billing.example.com"},
+	})
+	assert.NoError(suite.T(), err)
+}
+
+func requestResource(api http.Handler, resources ...string) *httptest.ResponseRecorder {
+	requestBody := url.Values{"grant_type": {"client_credentials"}, "resource": resources}
+	request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(requestBody.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth("audience", "audience_secret")
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *serverSuite) accessToken(response *httptest.ResponseRecorder) string {
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	var jsonTokenResponse tokenResponse
+	err := json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+	assert.NoError(suite.T(), err)
+	return jsonTokenResponse.AccessToken
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_SetsAudienceOfRequestedResource() {
+	// given a client that is allowed to access two APIs
+	suite.saveAudienceClient()
+
+	// when requesting a token for one of them
+	token := suite.accessToken(requestResource(suite.InitIdpApi(), "https://api.example.com"))
+
+	// then the token is restricted to that API
+	assert.Equal(suite.T(), "https://api.example.com", decodeSegment(suite, token, 1)["aud"])
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_SetsAudiencesOfRepeatedResources() {
+	// given a client that is allowed to access two APIs
+	suite.saveAudienceClient()
+
+	// when requesting a token for both of them
+	token := suite.accessToken(requestResource(suite.InitIdpApi(), "https://billing.example.com", "https://api.example.com"))
+
+	// then the token is issued for both APIs
+	assert.Equal(suite.T(), []interface{}{"https://billing.example.com", "https://api.example.com"}, decodeSegment(suite, token, 1)["aud"])
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_SetsAllowedAudiencesWithoutResource() {
+	// given a client that is allowed to access two APIs
+	suite.saveAudienceClient()
+
+	// when requesting a token without resource
+	token := suite.accessToken(requestResource(suite.InitIdpApi()))
+
+	// then the token is issued for all allowed APIs
+	assert.Equal(suite.T(), []interface{}{"https://api.example.com", "https://billing.example.com"}, decodeSegment(suite, token, 1)["aud"])
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_AcceptsResourceInJsonBody() {
+	// given a client that is allowed to access two APIs
+	suite.saveAudienceClient()
+
+	// when requesting a token for one of them with a JSON body
+	requestBody := `{"client_id":"audience","client_secret":"audience_secret","grant_type":"client_credentials","resource":"https://api.example.com"}`
+	response := httptest.NewRecorder()
+	suite.InitIdpApi().ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/token", bytes.NewBufferString(requestBody)))
+
+	// then the token is restricted to that API
+	assert.Equal(suite.T(), "https://api.example.com", decodeSegment(suite, suite.accessToken(response), 1)["aud"])
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_ReturnsInvalidTargetIfResourceIsNotAllowed() {
+	// given a client that is allowed to access two APIs
+	suite.saveAudienceClient()
+
+	// when requesting a token for another API
+	response := requestResource(suite.InitIdpApi(), "https://admin.example.com")
+
+	// then the response should be 400 Bad Request with an invalid_target error
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_target\",\"error_description\":\"The requested resource is not allowed\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_ReturnsInvalidTargetIfResourceIsNotAbsolute() {
+	// given a client that is allowed to access two APIs
+	suite.saveAudienceClient()
+
+	// when requesting a token for a relative resource
+	response := requestResource(suite.InitIdpApi(), "/api")
+
+	// then the response should be 400 Bad Request with an invalid_target error
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_target\",\"error_description\":\"The resource must be an absolute URI without fragment\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RestrictsAuthorizationCodeToResource() {
+	// given an authorization code for two APIs
+	suite.saveAudienceClient()
+	api := suite.InitIdpApi()
+	query := authorizationQuery()
+	query.Set("client_id", "audience")
+	query["resource"] = []string{"https://api.example.com", "https://billing.example.com"}
+	location, _ := url.Parse(authorize(api, query).Header().Get("Location"))
+
+	// when exchanging the code for one of them
+	requestBody := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {"http://localhost:3000/callback"},
+		"code_verifier": {codeVerifier},
+		"resource":      {"https://billing.example.com"},
+	}
+	request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(requestBody.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth("audience", "audience_secret")
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+
+	// then the access token is restricted to that API
+	assert.Equal(suite.T(), "https://billing.example.com", decodeSegment(suite, suite.accessToken(response), 1)["aud"])
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_KeepsAuthorizationCodeIfResourceIsNotAllowed() {
+	// given an authorization code for one API
+	suite.saveAudienceClient()
+	api := suite.InitIdpApi()
+	query := authorizationQuery()
+	query.Set("client_id", "audience")
+	query.Set("resource", "https://api.example.com")
+	location, _ := url.Parse(authorize(api, query).Header().Get("Location"))
+	exchange := func(resource string) *httptest.ResponseRecorder {
+		requestBody := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {location.Query().Get("code")},
+			"redirect_uri":  {"http://localhost:3000/callback"},
+			"code_verifier": {codeVerifier},
+			"resource":      {resource},
+		}
+		request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(requestBody.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth("audience", "audience_secret")
+		response := httptest.NewRecorder()
+		api.ServeHTTP(response, request)
+		return response
+	}
+
+	// when exchanging the code for another API
+	rejected := exchange("https://billing.example.com")
+
+	// then the request is rejected with an invalid_target error
+	assert.Equal(suite.T(), http.StatusBadRequest, rejected.Result().StatusCode)
+	assert.Contains(suite.T(), rejected.Body.String(), "invalid_target")
+
+	// and the code can still be exchanged for the API it was issued for
+	accepted := exchange("https://api.example.com")
+	assert.Equal(suite.T(), http.StatusOK, accepted.Result().StatusCode)
+	assert.Equal(suite.T(), "https://api.example.com", decodeSegment(suite, suite.accessToken(accepted), 1)["aud"])
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RedirectsWithErrorIfResourceIsNotAllowed() {
+	// when sending an authorization request for a resource the client may not access
+	query := authorizationQuery()
+	query.Set("resource", "https://admin.example.com")
+	response := authorize(suite.InitIdpApi(), query)
+
+	// then the client is redirected with an invalid_target error
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "invalid_target", location.Query().Get("error"))
+	assert.Empty(suite.T(), location.Query().Get("code"))
+}
+
+func (suite *serverSuite) Test_IntrospectEndpoint_ChecksAudienceOfResourceServer() {
+	// given a resource server and tokens for it and for another API
+	suite.saveAudienceClient()
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:           "api",
+		ClientSecret:       "api_secret",
+		AllowIntrospection: true,
+		Resource:           "https://api.example.com",
+	})
+	assert.NoError(suite.T(), err)
+	api := suite.InitIdpApi()
+	apiToken := suite.accessToken(requestResource(api, "https://api.example.com"))
+	billingToken := suite.accessToken(requestResource(api, "https://billing.example.com"))
+
+	introspectAsResourceServer := func(token string) string {
+		request := httptest.NewRequest(http.MethodPost, "/introspect", bytes.NewBufferString(`{"token":"`+token+`"}`))
+		request.SetBasicAuth("api", "api_secret")
+		response := httptest.NewRecorder()
+		api.ServeHTTP(response, request)
+		return response.Body.String()
+	}
+
+	// then only the token for the resource server is active
+	assert.Contains(suite.T(), introspectAsResourceServer(apiToken), "\"active\":true")
+	assert.Equal(suite.T(), "{\"active\":false}\n", introspectAsResourceServer(billingToken))
+}
@@ -0,0 +1,125 @@
+package idp
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type revokeRequest struct {
+	Token         *string `json:"token"`
+	TokenTypeHint string  `json:"token_type_hint"`
+	ClientId      string  `json:"client_id"`
+	ClientSecret  string  `json:"client_secret"`
+	AuthMethod    string  `json:"-"`
+}
+
+const (
+	tokenTypeHintAccessToken  = "access_token"
+	tokenTypeHintRefreshToken = "refresh_token"
+)
+
+// RevokeHandler handles the revocation of access and refresh tokens as described in RFC 7009.
+// The client authenticates like at the TokenHandler and may only revoke its own tokens.
+// Access tokens are revoked by their jti until they expire, so that the IntrospectHandler reports them as inactive.
+// Refresh tokens are revoked together with their whole family, so that no further access tokens can be obtained with them.
+//
+// The token_type_hint only decides which token type is looked up first, unknown hints are ignored.
+// If the request body is invalid or the token is nil, it responds with a 400 Bad Request status and an invalid_request error.
+// If the client is not authorized, it responds with a 401 Unauthorized status and an invalid_client error.
+// If the token was issued to another client, it responds with a 400 Bad Request status and an unauthorized_client error.
+// Otherwise, it responds with a 200 OK status, even if the token is invalid or already expired,
+// because the client has nothing left to do in that case.
+func (s *Server) RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	request, err := parseRevokeRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if _, ok := s.authenticateClient(request.ClientId, request.ClientSecret, request.AuthMethod); !ok {
+		writeError(w, r, NewError(ErrorInvalidClient, "Client authentication failed"))
+		return
+	}
+
+	revokers := []func(clientId string, token string) (bool, error){s.revokeAccessToken, s.revokeRefreshToken}
+	if request.TokenTypeHint == tokenTypeHintRefreshToken {
+		revokers = []func(clientId string, token string) (bool, error){s.revokeRefreshToken, s.revokeAccessToken}
+	}
+	for _, revoke := range revokers {
+		found, err := revoke(request.ClientId, *request.Token)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if found {
+			break
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeAccessToken records the revocation of the access token of the client and reports whether the token is an access token.
+// Tokens without jti can not be revoked and are treated like invalid tokens.
+func (s *Server) revokeAccessToken(clientId string, token string) (bool, error) {
+	claims, err := s.tokenClaims(token)
+	if err != nil {
+		return false, nil
+	}
+	tokenId, ok := claims["jti"].(string)
+	if !ok || tokenId == "" {
+		return false, nil
+	}
+	if tokenClientId(claims) != clientId {
+		return true, NewError(ErrorUnauthorizedClient, "Token was not issued to the client")
+	}
+
+	now := s.clock.Now()
+	if !claims.VerifyExpiresAt(now.Unix(), true) {
+		return true, nil
+	}
+
+	expiresAt, _ := claims["exp"].(float64)
+	return true, s.revocationRepository.RevokeToken(repository.RevokedToken{
+		TokenId:   tokenId,
+		ClientId:  clientId,
+		RevokedAt: now,
+		ExpiresAt: time.Unix(int64(expiresAt), 0),
+	})
+}
+
+// revokeRefreshToken revokes the family of the current refresh token of the client and reports whether the token is a refresh token.
+// Refresh tokens that were already rotated are treated like invalid tokens.
+func (s *Server) revokeRefreshToken(clientId string, token string) (bool, error) {
+	familyId, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false, nil
+	}
+
+	family, err := s.refreshTokenRepository.GetRefreshTokenFamily(familyId)
+	if err != nil || family.TokenHash != hashToken(token) {
+		return false, nil
+	}
+	if family.ClientId != clientId {
+		return true, NewError(ErrorUnauthorizedClient, "Token was not issued to the client")
+	}
+	if family.Revoked {
+		return true, nil
+	}
+	return true, s.refreshTokenRepository.RevokeRefreshTokenFamily(familyId)
+}
+
+// isRevoked reports whether the access token with the claims was revoked.
+// If the revocation can not be looked up, the token is considered revoked.
+func (s *Server) isRevoked(claims jwt.MapClaims) bool {
+	tokenId, ok := claims["jti"].(string)
+	if !ok {
+		return false
+	}
+	revoked, err := s.revocationRepository.IsRevoked(tokenId)
+	return err != nil || revoked
+}
@@ -0,0 +1,120 @@
+package idp_test
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+func revoke(api http.Handler, clientId string, clientSecret string, token string, tokenTypeHint string) *httptest.ResponseRecorder {
+	requestBody := url.Values{"token": {token}}
+	if tokenTypeHint != "" {
+		requestBody.Set("token_type_hint", tokenTypeHint)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(requestBody.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(clientId, clientSecret)
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *serverSuite) Test_RevokeEndpoint_RevokesAccessToken() {
+	// given an active access token
+	server := suite.server()
+	token := issueToken(suite, server)
+	assert.Equal(suite.T(), true, introspect(suite, server, token)["active"])
+
+	// when revoking the access token
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(`{"token":"`+token+`","token_type_hint":"access_token"}`))
+	request.SetBasicAuth("1234567890", "client_secret")
+	server.RevokeHandler(response, request)
+
+	// then the token is reported as inactive
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), map[string]interface{}{"active": false}, introspect(suite, server, token))
+}
+
+func (suite *serverSuite) Test_RevokeEndpoint_RevokesRefreshTokenFamily() {
+	// given a refresh token from the authorization code grant
+	api := suite.InitIdpApi()
+	refreshToken := suite.obtainRefreshToken(api)
+
+	// when revoking the refresh token
+	response := revoke(api, "1234567890", "client_secret", refreshToken, "refresh_token")
+
+	// then the refresh token can no longer be used
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), http.StatusBadRequest, refresh(api, refreshToken).Result().StatusCode)
+}
+
+func (suite *serverSuite) Test_RevokeEndpoint_RevokesRefreshTokenWithWrongHint() {
+	// given a refresh token from the authorization code grant
+	api := suite.InitIdpApi()
+	refreshToken := suite.obtainRefreshToken(api)
+
+	// when revoking the refresh token with the hint of an access token
+	response := revoke(api, "1234567890", "client_secret", refreshToken, "access_token")
+
+	// then the refresh token is revoked anyway
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	assert.Equal(suite.T(), http.StatusBadRequest, refresh(api, refreshToken).Result().StatusCode)
+}
+
+func (suite *serverSuite) Test_RevokeEndpoint_ReturnsOkForInvalidToken() {
+	// when revoking an unknown token
+	response := revoke(suite.InitIdpApi(), "1234567890", "client_secret", "invalid", "")
+
+	// then the response should be 200 OK as required by RFC 7009 section 2.2
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+}
+
+func (suite *serverSuite) Test_RevokeEndpoint_ReturnsUnauthorizedIfClientIsNotAuthenticated() {
+	// when revoking a token with wrong client credentials
+	server := suite.server()
+	token := issueToken(suite, server)
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(`{"token":"`+token+`"}`))
+	request.SetBasicAuth("1234567890", "wrong_secret")
+	server.RevokeHandler(response, request)
+
+	// then the response should be 401 Unauthorized and the token stays active
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_client\",\"error_description\":\"Client authentication failed\"}\n", response.Body.String())
+	assert.Equal(suite.T(), true, introspect(suite, server, token)["active"])
+}
+
+func (suite *serverSuite) Test_RevokeEndpoint_RejectsTokenOfOtherClient() {
+	// given an access token of another client
+	_, err := suite.clientRepository.SaveClient(repository.Client{ClientId: "other", ClientSecret: "other_secret"})
+	assert.NoError(suite.T(), err)
+	server := suite.server()
+	token := issueToken(suite, server)
+
+	// when the other client revokes the token
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(`{"token":"`+token+`"}`))
+	request.SetBasicAuth("other", "other_secret")
+	server.RevokeHandler(response, request)
+
+	// then the request is rejected and the token stays active
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"unauthorized_client\",\"error_description\":\"Token was not issued to the client\"}\n", response.Body.String())
+	assert.Equal(suite.T(), true, introspect(suite, server, token)["active"])
+}
+
+func (suite *serverSuite) Test_RevokeEndpoint_ReturnsBadRequestIfTokenIsMissing() {
+	// when revoking without a token
+	request := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(`{}`))
+	request.SetBasicAuth("1234567890", "client_secret")
+	response := httptest.NewRecorder()
+	suite.InitIdpApi().ServeHTTP(response, request)
+
+	// then the response should be 400 Bad Request
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_request\",\"error_description\":\"Invalid body\"}\n", response.Body.String())
+}
@@ -0,0 +1,27 @@
+package idp
+
+import (
+	"slices"
+	"strings"
+)
+
+// grantScope returns the scope that is granted for the requested scope, given the scopes the client is allowed to use.
+// Scopes are space-delimited as described in RFC 6749 section 3.3. Without a requested scope, all allowed scopes are granted.
+// If any requested scope is not allowed, it returns an invalid_scope error instead of silently dropping it,
+// so that the client does not mistake a narrower token for the one it asked for.
+func grantScope(requested string, allowed []string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " "), nil
+	}
+
+	var granted []string
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(allowed, scope) {
+			return "", NewError(ErrorInvalidScope, "The requested scope is not allowed")
+		}
+		if !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return strings.Join(granted, " "), nil
+}
@@ -0,0 +1,125 @@
+package idp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+func (suite *serverSuite) saveScopedClient() {
+	_, err := suite.clientRepository.SaveClient(repository.Client{
+		ClientId:     "scoped",
+		ClientSecret: "scoped_secret",
+		Scopes:       []string{"read:example", "write:example"},
+	})
+	assert.NoError(suite.T(), err)
+}
+
+func requestScope(api http.Handler, scope string) *httptest.ResponseRecorder {
+	requestBody := url.Values{"grant_type": {"client_credentials"}, "scope": {scope}}
+	request := httptest.NewRequest(http.MethodPost, "/token", bytes.NewBufferString(requestBody.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth("scoped", "scoped_secret")
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_GrantsRequestedScope() {
+	// given a client that is allowed to read and write
+	suite.saveScopedClient()
+
+	// when requesting only the write scope
+	response := requestScope(suite.InitIdpApi(), "write:example")
+
+	// then only the write scope is granted in the response and the token
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	var jsonTokenResponse tokenResponse
+	err := json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "write:example", jsonTokenResponse.Scope)
+	assert.Equal(suite.T(), "write:example", decodeSegment(suite, jsonTokenResponse.AccessToken, 1)["scope"])
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_GrantsAllAllowedScopesWithoutRequestedScope() {
+	// given a client that is allowed to read and write
+	suite.saveScopedClient()
+
+	// when requesting no scope
+	response := requestScope(suite.InitIdpApi(), "")
+
+	// then all allowed scopes are granted
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	var jsonTokenResponse tokenResponse
+	err := json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "read:example write:example", jsonTokenResponse.Scope)
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_ReturnsInvalidScopeIfScopeIsNotAllowed() {
+	// given a client that is allowed to read and write
+	suite.saveScopedClient()
+
+	// when requesting a scope the client is not allowed to use
+	response := requestScope(suite.InitIdpApi(), "read:example admin")
+
+	// then the response should be 400 Bad Request with an invalid_scope error
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Equal(suite.T(), "{\"error\":\"invalid_scope\",\"error_description\":\"The requested scope is not allowed\"}\n", response.Body.String())
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_OmitsScopeIfClientHasNoScopes() {
+	// given a client without scopes
+	_, err := suite.clientRepository.SaveClient(repository.Client{ClientId: "scoped", ClientSecret: "scoped_secret"})
+	assert.NoError(suite.T(), err)
+
+	// when requesting a token
+	response := requestScope(suite.InitIdpApi(), "")
+
+	// then the token carries no scope
+	assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode)
+	var jsonTokenResponse tokenResponse
+	err = json.NewDecoder(response.Body).Decode(&jsonTokenResponse)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), jsonTokenResponse.Scope)
+	assert.NotContains(suite.T(), decodeSegment(suite, jsonTokenResponse.AccessToken, 1), "scope")
+}
+
+func (suite *serverSuite) Test_AuthorizeEndpoint_RedirectsWithErrorIfScopeIsNotAllowed() {
+	// when sending an authorization request with a scope the client is not allowed to use
+	query := authorizationQuery()
+	query.Set("scope", "admin")
+	response := authorize(suite.InitIdpApi(), query)
+
+	// then the client is redirected with an invalid_scope error
+	assert.Equal(suite.T(), http.StatusFound, response.Result().StatusCode)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "invalid_scope", location.Query().Get("error"))
+	assert.Empty(suite.T(), location.Query().Get("code"))
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsRefreshWithBroaderScope() {
+	// given a refresh token for the read scope
+	api := suite.InitIdpApi()
+	refreshToken := suite.obtainRefreshToken(api)
+
+	// when refreshing with a scope that was not originally granted
+	requestBody, _ := json.Marshal(map[string]string{
+		"grant_type":    "refresh_token",
+		"client_id":     "1234567890",
+		"client_secret": "client_secret",
+		"refresh_token": refreshToken,
+		"scope":         "write:example",
+	})
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/token", bytes.NewBuffer(requestBody)))
+
+	// then the response should be 400 Bad Request with an invalid_scope error
+	assert.Equal(suite.T(), http.StatusBadRequest, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "invalid_scope")
+}
@@ -0,0 +1,75 @@
+package idp_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func clientCredentialsGrant(api http.Handler, clientId string, clientSecret string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(clientId, clientSecret)
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_AcceptsAllUnexpiredSecrets() {
+	// given a second secret of the example client, which has not expired yet
+	_, err := suite.clientRepository.AddClientSecret("1234567890", "rotated_secret", suite.clock.Now().Add(time.Hour))
+	assert.NoError(suite.T(), err)
+
+	// when the client authenticates with either secret
+	api := suite.InitIdpApi()
+	for _, secret := range []string{"client_secret", "rotated_secret"} {
+		response := clientCredentialsGrant(api, "1234567890", secret)
+
+		// then a token is issued
+		assert.Equal(suite.T(), http.StatusOK, response.Result().StatusCode, secret)
+	}
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsExpiredSecret() {
+	// given a second secret of the example client, which has expired
+	_, err := suite.clientRepository.AddClientSecret("1234567890", "expired_secret", suite.clock.Now())
+	assert.NoError(suite.T(), err)
+
+	// when the client authenticates with the expired secret
+	response := clientCredentialsGrant(suite.InitIdpApi(), "1234567890", "expired_secret")
+
+	// then the response should be 401 Unauthorized with an invalid_client error
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "invalid_client")
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsRetiredSecret() {
+	// given a client, whose original secret was retired after a new one was added
+	_, err := suite.clientRepository.AddClientSecret("1234567890", "new_secret", time.Time{})
+	assert.NoError(suite.T(), err)
+	client, err := suite.clientRepository.GetClient("1234567890")
+	assert.NoError(suite.T(), err)
+	err = suite.clientRepository.RetireClientSecret("1234567890", client.Secrets[0].SecretId)
+	assert.NoError(suite.T(), err)
+
+	// when the client authenticates with the retired and the new secret
+	api := suite.InitIdpApi()
+	retired := clientCredentialsGrant(api, "1234567890", "client_secret")
+	current := clientCredentialsGrant(api, "1234567890", "new_secret")
+
+	// then only the new secret is accepted
+	assert.Equal(suite.T(), http.StatusUnauthorized, retired.Result().StatusCode)
+	assert.Equal(suite.T(), http.StatusOK, current.Result().StatusCode)
+}
+
+func (suite *serverSuite) Test_TokenEndpoint_RejectsUnknownClientWithoutSecret() {
+	// when an unknown client requests a token without secret
+	response := clientCredentialsGrant(suite.InitIdpApi(), "unknown", "")
+
+	// then the response should be 401 Unauthorized with an invalid_client error
+	assert.Equal(suite.T(), http.StatusUnauthorized, response.Result().StatusCode)
+	assert.Contains(suite.T(), response.Body.String(), "invalid_client")
+}
@@ -0,0 +1,153 @@
+package idp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/thanhpk/randstr"
+	"strings"
+	"time"
+)
+
+// tokenTypeAccessToken is the typ header of access tokens as described in RFC 9068 section 2.1.
+const tokenTypeAccessToken = "at+jwt"
+
+const defaultAccessTokenLifetime = time.Hour
+
+var errInvalidToken = errors.New("invalid token")
+
+// clientAccessTokenLifetime returns the AccessTokenLifetime of the client, or the default lifetime of the server.
+func (s *Server) clientAccessTokenLifetime(client *repository.Client) time.Duration {
+	return lifetimeOf(client.AccessTokenLifetime, s.accessTokenLifetime)
+}
+
+// lifetimeOf returns the lifetime a client configured, or the default lifetime if the client did not configure one.
+func lifetimeOf(clientLifetime time.Duration, defaultLifetime time.Duration) time.Duration {
+	if clientLifetime > 0 {
+		return clientLifetime
+	}
+	return defaultLifetime
+}
+
+// issueAccessToken creates an access token for the subject and the client, which expires after the lifetime.
+// The claims follow the JWT profile of RFC 9068, which requires the iss and aud claims.
+// Without audiences, the audience of the server is used. The scope claim is only set if the scope is not empty.
+// Clients with the TokenFormatOpaque receive a random handle instead of a JWT, whose claims are stored in the TokenRepository.
+func (s *Server) issueAccessToken(client *repository.Client, subject string, scope string, audiences []string, lifetime time.Duration) (string, error) {
+	if len(audiences) == 0 {
+		audiences = []string{s.audience}
+	}
+
+	now := s.clock.Now()
+	claims := jwt.MapClaims{
+		"iss":       s.issuer,
+		"aud":       audienceClaim(audiences),
+		"sub":       subject,
+		"client_id": client.ClientId,
+		"iat":       now.Unix(),
+		"exp":       now.Add(lifetime).Unix(),
+		"jti":       randstr.String(32),
+	}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	if client.TokenFormat == repository.TokenFormatOpaque {
+		return s.issueReferenceToken(claims, now.Add(lifetime))
+	}
+	return s.signToken(tokenTypeAccessToken, claims)
+}
+
+// issueReferenceToken stores the claims under the hash of a new opaque token and returns the token.
+// The token contains no dot, so that it can not be mistaken for a JWT or a refresh token.
+func (s *Server) issueReferenceToken(claims jwt.MapClaims, expiresAt time.Time) (string, error) {
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	token := randstr.String(43)
+	err = s.tokenRepository.SaveToken(repository.ReferenceToken{
+		TokenHash: hashToken(token),
+		Claims:    encoded,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// tokenClaims returns the claims of an access token, which is either a JWT or an opaque token of the TokenRepository.
+// JWTs must have the typ header at+jwt, so that ID tokens, which are signed with the same key, are not accepted as access tokens.
+// Like parseToken, it does not validate time based claims.
+func (s *Server) tokenClaims(token string) (jwt.MapClaims, error) {
+	if strings.Contains(token, ".") {
+		return s.parseToken(token, tokenTypeAccessToken)
+	}
+
+	reference, err := s.tokenRepository.GetToken(hashToken(token))
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	claims := jwt.MapClaims{}
+	if err := json.Unmarshal(reference.Claims, &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
+
+// signToken creates a JWT of the type with the claims, signed by the signing key of the server.
+func (s *Server) signToken(tokenType string, claims jwt.MapClaims) (string, error) {
+	key, err := s.keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := &jwt.Token{
+		Header: map[string]interface{}{
+			"typ": tokenType,
+			"alg": key.Algorithm(),
+			"kid": key.Id(),
+		},
+		Claims: claims,
+	}
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+	signature, err := key.Sign(signingString)
+	if err != nil {
+		return "", err
+	}
+	return signingString + "." + signature, nil
+}
+
+// parseToken verifies the signature of the JWT with the key that is referenced by its kid header and returns its claims.
+// The alg header must match the algorithm of the key, so that a token can not switch to a weaker algorithm,
+// and the typ header must match the tokenType, optionally with the application/ prefix of RFC 8725 section 3.11.
+// Time based claims are not validated, that is up to the caller, which knows the clock of the server.
+func (s *Server) parseToken(tokenString string, tokenType string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, parts, err := new(jwt.Parser).ParseUnverified(tokenString, claims)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	typ, _ := token.Header["typ"].(string)
+	if !strings.EqualFold(strings.TrimPrefix(strings.ToLower(typ), "application/"), tokenType) {
+		return nil, errInvalidToken
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys.VerificationKey(kid)
+	if !ok || token.Header["alg"] != key.Algorithm() {
+		return nil, errInvalidToken
+	}
+
+	if err := key.Verify(strings.Join(parts[0:2], "."), parts[2]); err != nil {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,433 @@
+// Package kmslocal contains a local stand-in for the AWS KMS API.
+// It implements the operations that are used for token signing and for the envelope encryption of key rings,
+// so that KMS backed keys can be used in tests and local development without an AWS account.
+package kmslocal
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Server is an http.Handler that answers KMS requests of the AWS SDK.
+// Keys are identified by the key id they were added with.
+type Server struct {
+	mu   sync.Mutex
+	keys map[string]interface{}
+}
+
+type generateMacRequest struct {
+	KeyId        string
+	MacAlgorithm string
+	Message      []byte
+}
+
+type verifyMacRequest struct {
+	KeyId        string
+	MacAlgorithm string
+	Message      []byte
+	Mac          []byte
+}
+
+type signRequest struct {
+	KeyId            string
+	Message          []byte
+	MessageType      string
+	SigningAlgorithm string
+}
+
+type getPublicKeyRequest struct {
+	KeyId string
+}
+
+type generateDataKeyRequest struct {
+	KeyId             string
+	KeySpec           string
+	NumberOfBytes     int
+	EncryptionContext map[string]string
+}
+
+type decryptRequest struct {
+	CiphertextBlob    []byte
+	KeyId             string
+	EncryptionContext map[string]string
+}
+
+// symmetricKey is a SYMMETRIC_DEFAULT key, which is distinguished from the secret of an HMAC key by its type.
+type symmetricKey []byte
+
+// AddHmacKey adds an HMAC_256 key with the given secret.
+func (s *Server) AddHmacKey(keyId string, secret []byte) {
+	s.addKey(keyId, secret)
+}
+
+// AddRsaKey adds an RSA_2048 key for signing.
+func (s *Server) AddRsaKey(keyId string, privateKey *rsa.PrivateKey) {
+	s.addKey(keyId, privateKey)
+}
+
+// AddEcdsaKey adds an ECC_NIST_P256 key for signing.
+func (s *Server) AddEcdsaKey(keyId string, privateKey *ecdsa.PrivateKey) {
+	s.addKey(keyId, privateKey)
+}
+
+// AddSymmetricKey adds a SYMMETRIC_DEFAULT key for encryption. The key must be 32 bytes long.
+func (s *Server) AddSymmetricKey(keyId string, key []byte) {
+	s.addKey(keyId, symmetricKey(key))
+}
+
+func (s *Server) addKey(keyId string, key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyId] = key
+}
+
+func (s *Server) key(keyId string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[keyId]
+	return key, ok
+}
+
+// ServeHTTP dispatches the request to the operation in the X-Amz-Target header.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "TrentService.")
+	switch operation {
+	case "GenerateMac":
+		s.generateMac(w, r)
+	case "VerifyMac":
+		s.verifyMac(w, r)
+	case "Sign":
+		s.sign(w, r)
+	case "GetPublicKey":
+		s.getPublicKey(w, r)
+	case "GenerateDataKey":
+		s.generateDataKey(w, r)
+	case "Decrypt":
+		s.decrypt(w, r)
+	default:
+		writeError(w, "UnsupportedOperationException", "Operation "+operation+" is not supported")
+	}
+}
+
+func (s *Server) generateMac(w http.ResponseWriter, r *http.Request) {
+	request := generateMacRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, "ValidationException", err.Error())
+		return
+	}
+
+	secret, ok := s.hmacKey(w, request.KeyId, request.MacAlgorithm)
+	if !ok {
+		return
+	}
+
+	writeResponse(w, map[string]interface{}{
+		"KeyId":        request.KeyId,
+		"MacAlgorithm": request.MacAlgorithm,
+		"Mac":          computeMac(secret, request.Message),
+	})
+}
+
+func (s *Server) verifyMac(w http.ResponseWriter, r *http.Request) {
+	request := verifyMacRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, "ValidationException", err.Error())
+		return
+	}
+
+	secret, ok := s.hmacKey(w, request.KeyId, request.MacAlgorithm)
+	if !ok {
+		return
+	}
+
+	if !hmac.Equal(computeMac(secret, request.Message), request.Mac) {
+		writeError(w, "KMSInvalidMacException", "The MAC is not valid")
+		return
+	}
+
+	writeResponse(w, map[string]interface{}{
+		"KeyId":        request.KeyId,
+		"MacAlgorithm": request.MacAlgorithm,
+		"MacValid":     true,
+	})
+}
+
+func (s *Server) sign(w http.ResponseWriter, r *http.Request) {
+	request := signRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, "ValidationException", err.Error())
+		return
+	}
+
+	key, ok := s.key(request.KeyId)
+	if !ok {
+		writeError(w, "NotFoundException", "Key "+request.KeyId+" does not exist")
+		return
+	}
+
+	digest := request.Message
+	if request.MessageType != "DIGEST" {
+		hash := sha256.Sum256(request.Message)
+		digest = hash[:]
+	}
+
+	var signature []byte
+	var err error
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if request.SigningAlgorithm != "RSASSA_PKCS1_V1_5_SHA_256" {
+			writeError(w, "InvalidKeyUsageException", "Signing algorithm "+request.SigningAlgorithm+" is not supported by the key")
+			return
+		}
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+	case *ecdsa.PrivateKey:
+		if request.SigningAlgorithm != "ECDSA_SHA_256" {
+			writeError(w, "InvalidKeyUsageException", "Signing algorithm "+request.SigningAlgorithm+" is not supported by the key")
+			return
+		}
+		signature, err = ecdsa.SignASN1(rand.Reader, key, digest)
+	default:
+		writeError(w, "InvalidKeyUsageException", "Key "+request.KeyId+" can not be used for signing")
+		return
+	}
+	if err != nil {
+		writeError(w, "KMSInternalException", err.Error())
+		return
+	}
+
+	writeResponse(w, map[string]interface{}{
+		"KeyId":            request.KeyId,
+		"SigningAlgorithm": request.SigningAlgorithm,
+		"Signature":        signature,
+	})
+}
+
+func (s *Server) getPublicKey(w http.ResponseWriter, r *http.Request) {
+	request := getPublicKeyRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, "ValidationException", err.Error())
+		return
+	}
+
+	key, ok := s.key(request.KeyId)
+	if !ok {
+		writeError(w, "NotFoundException", "Key "+request.KeyId+" does not exist")
+		return
+	}
+
+	var publicKey interface{}
+	var keySpec, signingAlgorithm string
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		publicKey, keySpec, signingAlgorithm = &key.PublicKey, "RSA_2048", "RSASSA_PKCS1_V1_5_SHA_256"
+	case *ecdsa.PrivateKey:
+		publicKey, keySpec, signingAlgorithm = &key.PublicKey, "ECC_NIST_P256", "ECDSA_SHA_256"
+	default:
+		writeError(w, "UnsupportedOperationException", "Key "+request.KeyId+" is not an asymmetric key")
+		return
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		writeError(w, "KMSInternalException", err.Error())
+		return
+	}
+
+	writeResponse(w, map[string]interface{}{
+		"KeyId":             request.KeyId,
+		"KeySpec":           keySpec,
+		"KeyUsage":          "SIGN_VERIFY",
+		"PublicKey":         der,
+		"SigningAlgorithms": []string{signingAlgorithm},
+	})
+}
+
+func (s *Server) generateDataKey(w http.ResponseWriter, r *http.Request) {
+	request := generateDataKeyRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, "ValidationException", err.Error())
+		return
+	}
+
+	key, ok := s.symmetricKey(w, request.KeyId)
+	if !ok {
+		return
+	}
+
+	size := request.NumberOfBytes
+	switch request.KeySpec {
+	case "AES_256":
+		size = 32
+	case "AES_128":
+		size = 16
+	}
+	if size <= 0 || size > 1024 {
+		writeError(w, "ValidationException", "Either KeySpec or NumberOfBytes is required")
+		return
+	}
+
+	plaintext := make([]byte, size)
+	if _, err := rand.Read(plaintext); err != nil {
+		writeError(w, "KMSInternalException", err.Error())
+		return
+	}
+	ciphertext, err := seal(request.KeyId, key, plaintext, request.EncryptionContext)
+	if err != nil {
+		writeError(w, "KMSInternalException", err.Error())
+		return
+	}
+
+	writeResponse(w, map[string]interface{}{
+		"KeyId":          request.KeyId,
+		"CiphertextBlob": ciphertext,
+		"Plaintext":      plaintext,
+	})
+}
+
+func (s *Server) decrypt(w http.ResponseWriter, r *http.Request) {
+	request := decryptRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, "ValidationException", err.Error())
+		return
+	}
+
+	// Like the ciphertexts of KMS, the ciphertexts of the stand-in contain the id of their key.
+	keyId, sealed, err := splitCiphertext(request.CiphertextBlob)
+	if err != nil || (request.KeyId != "" && request.KeyId != keyId) {
+		writeError(w, "InvalidCiphertextException", "The ciphertext is not valid")
+		return
+	}
+	key, ok := s.symmetricKey(w, keyId)
+	if !ok {
+		return
+	}
+
+	plaintext, err := open(key, sealed, request.EncryptionContext)
+	if err != nil {
+		writeError(w, "InvalidCiphertextException", "The ciphertext is not valid")
+		return
+	}
+
+	writeResponse(w, map[string]interface{}{
+		"KeyId":     keyId,
+		"Plaintext": plaintext,
+	})
+}
+
+func (s *Server) symmetricKey(w http.ResponseWriter, keyId string) (symmetricKey, bool) {
+	key, ok := s.key(keyId)
+	if !ok {
+		writeError(w, "NotFoundException", "Key "+keyId+" does not exist")
+		return nil, false
+	}
+
+	symmetric, ok := key.(symmetricKey)
+	if !ok {
+		writeError(w, "InvalidKeyUsageException", "Key "+keyId+" can not be used for encryption")
+		return nil, false
+	}
+	return symmetric, true
+}
+
+// seal encrypts the plaintext with AES-GCM, authenticating the encryption context as additional data,
+// and prefixes the ciphertext with the key id and the nonce.
+func seal(keyId string, key symmetricKey, plaintext []byte, encryptionContext map[string]string) ([]byte, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := append([]byte{byte(len(keyId))}, keyId...)
+	ciphertext = append(ciphertext, nonce...)
+	return gcm.Seal(ciphertext, nonce, plaintext, additionalData(encryptionContext)), nil
+}
+
+func open(key symmetricKey, sealed []byte, encryptionContext map[string]string) ([]byte, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData(encryptionContext))
+}
+
+func splitCiphertext(ciphertext []byte) (string, []byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext) < 1+int(ciphertext[0]) {
+		return "", nil, errors.New("ciphertext too short")
+	}
+	return string(ciphertext[1 : 1+int(ciphertext[0])]), ciphertext[1+int(ciphertext[0]):], nil
+}
+
+func newGcm(key symmetricKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData encodes the encryption context, whose keys encoding/json sorts, so that the same context always yields the same data.
+func additionalData(encryptionContext map[string]string) []byte {
+	if len(encryptionContext) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(encryptionContext)
+	return data
+}
+
+func (s *Server) hmacKey(w http.ResponseWriter, keyId string, macAlgorithm string) ([]byte, bool) {
+	key, ok := s.key(keyId)
+	if !ok {
+		writeError(w, "NotFoundException", "Key "+keyId+" does not exist")
+		return nil, false
+	}
+
+	secret, ok := key.([]byte)
+	if !ok || macAlgorithm != "HMAC_SHA_256" {
+		writeError(w, "InvalidKeyUsageException", "Key "+keyId+" does not support "+macAlgorithm)
+		return nil, false
+	}
+	return secret, true
+}
+
+func computeMac(secret []byte, message []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func writeResponse(w http.ResponseWriter, response map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func writeError(w http.ResponseWriter, errorType string, message string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"__type": errorType, "message": message})
+}
+
+// New creates a KMS stand-in without any keys.
+func New() *Server {
+	return &Server{
+		keys: map[string]interface{}{},
+	}
+}
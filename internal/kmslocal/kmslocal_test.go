@@ -0,0 +1,239 @@
+package kmslocal_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/daschaa/open-idp/internal/idp"
+	"github.com/daschaa/open-idp/internal/kmslocal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+)
+
+type kmsSuite struct {
+	suite.Suite
+	kms    *kmslocal.Server
+	client *kms.Client
+}
+
+func (suite *kmsSuite) SetupTest() {
+	suite.kms = kmslocal.New()
+	server := httptest.NewServer(suite.kms)
+	suite.T().Cleanup(server.Close)
+	suite.client = idp.NewLocalKmsClient(server.URL)
+}
+
+func (suite *kmsSuite) Test_GenerateMac_IsVerifiedByVerifyMac() {
+	// given an HMAC key
+	suite.kms.AddHmacKey("hmac-key", []byte("kms_secret"))
+
+	// when generating the MAC of a message
+	generated, err := suite.client.GenerateMac(context.TODO(), &kms.GenerateMacInput{
+		KeyId:        aws.String("hmac-key"),
+		MacAlgorithm: types.MacAlgorithmSpecHmacSha256,
+		Message:      []byte("message"),
+	})
+	assert.NoError(suite.T(), err)
+
+	// then it is the HMAC-SHA256 of the secret
+	mac := hmac.New(sha256.New, []byte("kms_secret"))
+	mac.Write([]byte("message"))
+	assert.Equal(suite.T(), mac.Sum(nil), generated.Mac)
+
+	// and it is verified for the message, but not for another message
+	verified, err := suite.client.VerifyMac(context.TODO(), &kms.VerifyMacInput{
+		KeyId:        aws.String("hmac-key"),
+		MacAlgorithm: types.MacAlgorithmSpecHmacSha256,
+		Message:      []byte("message"),
+		Mac:          generated.Mac,
+	})
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), verified.MacValid)
+	_, err = suite.client.VerifyMac(context.TODO(), &kms.VerifyMacInput{
+		KeyId:        aws.String("hmac-key"),
+		MacAlgorithm: types.MacAlgorithmSpecHmacSha256,
+		Message:      []byte("other message"),
+		Mac:          generated.Mac,
+	})
+	var invalidMac *types.KMSInvalidMacException
+	assert.ErrorAs(suite.T(), err, &invalidMac)
+}
+
+func (suite *kmsSuite) Test_Sign_ReturnsDerSignatureOfEcdsaKey() {
+	// given an ECDSA key
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(suite.T(), err)
+	suite.kms.AddEcdsaKey("ecdsa-key", privateKey)
+
+	// when signing a message
+	signed, err := suite.client.Sign(context.TODO(), &kms.SignInput{
+		KeyId:            aws.String("ecdsa-key"),
+		Message:          []byte("message"),
+		MessageType:      types.MessageTypeRaw,
+		SigningAlgorithm: types.SigningAlgorithmSpecEcdsaSha256,
+	})
+	assert.NoError(suite.T(), err)
+
+	// then the signature is ASN.1 DER encoded like the signatures of KMS
+	digest := sha256.Sum256([]byte("message"))
+	assert.True(suite.T(), ecdsa.VerifyASN1(&privateKey.PublicKey, digest[:], signed.Signature))
+}
+
+func (suite *kmsSuite) Test_Sign_ReturnsPkcs1SignatureOfRsaKey() {
+	// given an RSA key
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(suite.T(), err)
+	suite.kms.AddRsaKey("rsa-key", privateKey)
+
+	// when signing the digest of a message
+	digest := sha256.Sum256([]byte("message"))
+	signed, err := suite.client.Sign(context.TODO(), &kms.SignInput{
+		KeyId:            aws.String("rsa-key"),
+		Message:          digest[:],
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	})
+	assert.NoError(suite.T(), err)
+
+	// then the signature is a PKCS #1 v1.5 signature of the digest
+	assert.NoError(suite.T(), rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, digest[:], signed.Signature))
+}
+
+func (suite *kmsSuite) Test_Sign_RejectsUnknownKeyAndWrongAlgorithm() {
+	// given an ECDSA key
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(suite.T(), err)
+	suite.kms.AddEcdsaKey("ecdsa-key", privateKey)
+
+	// when signing with an unknown key, or with an algorithm the key does not support
+	_, unknownErr := suite.client.Sign(context.TODO(), &kms.SignInput{
+		KeyId:            aws.String("unknown-key"),
+		Message:          []byte("message"),
+		SigningAlgorithm: types.SigningAlgorithmSpecEcdsaSha256,
+	})
+	_, algorithmErr := suite.client.Sign(context.TODO(), &kms.SignInput{
+		KeyId:            aws.String("ecdsa-key"),
+		Message:          []byte("message"),
+		SigningAlgorithm: types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	})
+
+	// then the errors of KMS are returned
+	var notFound *types.NotFoundException
+	assert.ErrorAs(suite.T(), unknownErr, &notFound)
+	var invalidKeyUsage *types.InvalidKeyUsageException
+	assert.ErrorAs(suite.T(), algorithmErr, &invalidKeyUsage)
+}
+
+func (suite *kmsSuite) Test_GetPublicKey_ReturnsPublicKeyOfKey() {
+	// given an ECDSA key
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(suite.T(), err)
+	suite.kms.AddEcdsaKey("ecdsa-key", privateKey)
+
+	// when requesting its public key
+	output, err := suite.client.GetPublicKey(context.TODO(), &kms.GetPublicKeyInput{KeyId: aws.String("ecdsa-key")})
+	assert.NoError(suite.T(), err)
+
+	// then the DER encoded public key and its signing algorithm are returned
+	publicKey, err := x509.ParsePKIXPublicKey(output.PublicKey)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), privateKey.PublicKey.Equal(publicKey))
+	assert.Equal(suite.T(), types.KeySpecEccNistP256, output.KeySpec)
+	assert.Equal(suite.T(), []types.SigningAlgorithmSpec{types.SigningAlgorithmSpecEcdsaSha256}, output.SigningAlgorithms)
+}
+
+func (suite *kmsSuite) Test_KmsSigningKey_ConvertsDerSignatureToJws() {
+	// given a signing key of an ECDSA key in KMS
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(suite.T(), err)
+	suite.kms.AddEcdsaKey("ecdsa-key", privateKey)
+	key, err := idp.NewKmsSigningKey("kms", suite.client, "ecdsa-key")
+	assert.NoError(suite.T(), err)
+
+	// when signing a token
+	signature, err := key.Sign("header.payload")
+	assert.NoError(suite.T(), err)
+
+	// then the signature is the fixed size R || S of RFC 7518 section 3.4, which the key verifies
+	raw, err := base64.RawURLEncoding.DecodeString(signature)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), raw, 64)
+	digest := sha256.Sum256([]byte("header.payload"))
+	r, s := new(big.Int).SetBytes(raw[:32]), new(big.Int).SetBytes(raw[32:])
+	assert.True(suite.T(), ecdsa.Verify(&privateKey.PublicKey, digest[:], r, s))
+	assert.NoError(suite.T(), key.Verify("header.payload", signature))
+	assert.Error(suite.T(), key.Verify("header.other", signature))
+}
+
+func (suite *kmsSuite) Test_GenerateDataKey_IsDecryptedWithItsEncryptionContext() {
+	// given a data key, which was generated with an encryption context
+	suite.kms.AddSymmetricKey("key-ring-key", make([]byte, 32))
+	generated, err := suite.client.GenerateDataKey(context.TODO(), &kms.GenerateDataKeyInput{
+		KeyId:             aws.String("key-ring-key"),
+		KeySpec:           types.DataKeySpecAes256,
+		EncryptionContext: map[string]string{"keyId": "signing-key"},
+	})
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), generated.Plaintext, 32)
+	assert.NotContains(suite.T(), string(generated.CiphertextBlob), string(generated.Plaintext))
+
+	// when decrypting it with the same encryption context
+	decrypted, err := suite.client.Decrypt(context.TODO(), &kms.DecryptInput{
+		KeyId:             aws.String("key-ring-key"),
+		CiphertextBlob:    generated.CiphertextBlob,
+		EncryptionContext: map[string]string{"keyId": "signing-key"},
+	})
+
+	// then the plaintext of the data key is returned
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), generated.Plaintext, decrypted.Plaintext)
+	assert.Equal(suite.T(), "key-ring-key", aws.ToString(decrypted.KeyId))
+
+	// and it can not be decrypted with another or without encryption context
+	for _, encryptionContext := range []map[string]string{{"keyId": "other-key"}, nil} {
+		_, err = suite.client.Decrypt(context.TODO(), &kms.DecryptInput{
+			CiphertextBlob:    generated.CiphertextBlob,
+			EncryptionContext: encryptionContext,
+		})
+		var invalidCiphertext *types.InvalidCiphertextException
+		assert.ErrorAs(suite.T(), err, &invalidCiphertext)
+	}
+}
+
+func (suite *kmsSuite) Test_Decrypt_RejectsCiphertextOfOtherKey() {
+	// given a data key of one symmetric key
+	suite.kms.AddSymmetricKey("key-ring-key", make([]byte, 32))
+	suite.kms.AddSymmetricKey("other-key", make([]byte, 32))
+	generated, err := suite.client.GenerateDataKey(context.TODO(), &kms.GenerateDataKeyInput{
+		KeyId:   aws.String("key-ring-key"),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	assert.NoError(suite.T(), err)
+
+	// when decrypting it with another key id
+	_, err = suite.client.Decrypt(context.TODO(), &kms.DecryptInput{
+		KeyId:          aws.String("other-key"),
+		CiphertextBlob: generated.CiphertextBlob,
+	})
+
+	// then the ciphertext is rejected
+	var invalidCiphertext *types.InvalidCiphertextException
+	assert.ErrorAs(suite.T(), err, &invalidCiphertext)
+}
+
+func TestKmsSuite(t *testing.T) {
+	suite.Run(t, new(kmsSuite))
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"slices"
+)
+
+// ClientSchemaVersion is the version of the Client layout, which the repositories store with every client.
+// It is incremented with every change that older versions of the server can not read correctly.
+const ClientSchemaVersion = 1
+
+// Grant types of the token and authorization endpoints, which clients may be restricted to with GrantTypes.
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypePassword          = "password"
+	GrantTypeImplicit          = "implicit"
+)
+
+// Response types of the authorization endpoint, which clients may be restricted to with ResponseTypes.
+const (
+	ResponseTypeCode  = "code"
+	ResponseTypeToken = "token"
+)
+
+// Authentication methods of clients at the token endpoint.
+const (
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodNone              = "none"
+)
+
+// errPublicClientSecret is returned by AddClientSecret for clients with the token endpoint auth method none.
+var errPublicClientSecret = invalidClientMetadata("clients with the token endpoint auth method none can not have secrets")
+
+var (
+	grantTypes    = []string{GrantTypeAuthorizationCode, GrantTypeClientCredentials, GrantTypeRefreshToken, GrantTypePassword, GrantTypeImplicit}
+	responseTypes = []string{ResponseTypeCode, ResponseTypeToken}
+	authMethods   = []string{AuthMethodClientSecretBasic, AuthMethodClientSecretPost, AuthMethodNone}
+)
+
+// Validate checks the metadata of the client. The error wraps ErrInvalidClientMetadata and names the invalid field.
+func (c Client) Validate() error {
+	if c.ClientId == "" {
+		return invalidClientMetadata("the client id is required")
+	}
+	for _, redirectUri := range c.RedirectUris {
+		parsed, err := url.Parse(redirectUri)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			return invalidClientMetadata("the redirect URI %q is not an absolute URI without fragment", redirectUri)
+		}
+	}
+	for _, grantType := range c.GrantTypes {
+		if !slices.Contains(grantTypes, grantType) {
+			return invalidClientMetadata("the grant type %q is not supported", grantType)
+		}
+	}
+	for _, responseType := range c.ResponseTypes {
+		if !slices.Contains(responseTypes, responseType) {
+			return invalidClientMetadata("the response type %q is not supported", responseType)
+		}
+	}
+	if len(c.GrantTypes) > 0 {
+		// RFC 7591 section 2.1 pairs every response type with the grant type that uses it.
+		if slices.Contains(c.ResponseTypes, ResponseTypeCode) && !c.AllowsGrantType(GrantTypeAuthorizationCode) {
+			return invalidClientMetadata("the response type code requires the grant type authorization_code")
+		}
+		if slices.Contains(c.ResponseTypes, ResponseTypeToken) && !c.AllowsGrantType(GrantTypeImplicit) {
+			return invalidClientMetadata("the response type token requires the grant type implicit")
+		}
+		if (c.AllowsGrantType(GrantTypeAuthorizationCode) || c.AllowsGrantType(GrantTypeImplicit)) && len(c.RedirectUris) == 0 {
+			return invalidClientMetadata("the grant types authorization_code and implicit require a redirect URI")
+		}
+		if c.AllowsGrantType(GrantTypePassword) && !c.AllowPasswordGrant {
+			return invalidClientMetadata("the grant type password requires AllowPasswordGrant")
+		}
+		if c.AllowsGrantType(GrantTypeImplicit) && !c.AllowImplicitGrant {
+			return invalidClientMetadata("the grant type implicit requires AllowImplicitGrant")
+		}
+	}
+	if c.TokenFormat != "" && c.TokenFormat != TokenFormatJwt && c.TokenFormat != TokenFormatOpaque {
+		return invalidClientMetadata("the token format %q is not supported", c.TokenFormat)
+	}
+	if c.AccessTokenLifetime < 0 || c.RefreshTokenLifetime < 0 || c.IdTokenLifetime < 0 {
+		return invalidClientMetadata("token lifetimes must not be negative")
+	}
+	if c.TokenEndpointAuthMethod != "" && !slices.Contains(authMethods, c.TokenEndpointAuthMethod) {
+		return invalidClientMetadata("the token endpoint auth method %q is not supported", c.TokenEndpointAuthMethod)
+	}
+	if c.TokenEndpointAuthMethod == AuthMethodNone {
+		if c.ClientSecret != "" || len(c.Secrets) > 0 {
+			return errPublicClientSecret
+		}
+		if slices.Contains(c.GrantTypes, GrantTypeClientCredentials) {
+			return invalidClientMetadata("the grant type client_credentials requires a client secret")
+		}
+	}
+	if c.Jwks != "" && c.JwksUri != "" {
+		return invalidClientMetadata("only one of jwks and jwks_uri may be set")
+	}
+	if c.Jwks != "" {
+		var jwks struct {
+			Keys []json.RawMessage `json:"keys"`
+		}
+		if err := json.Unmarshal([]byte(c.Jwks), &jwks); err != nil || jwks.Keys == nil {
+			return invalidClientMetadata("jwks is not a JSON Web Key Set")
+		}
+	}
+	if c.JwksUri != "" && !isHttpsUri(c.JwksUri) {
+		return invalidClientMetadata("jwks_uri %q is not an https URI", c.JwksUri)
+	}
+	if c.LogoUri != "" && !isHttpUri(c.LogoUri) {
+		return invalidClientMetadata("logo_uri %q is not an http or https URI", c.LogoUri)
+	}
+	if c.PolicyUri != "" && !isHttpUri(c.PolicyUri) {
+		return invalidClientMetadata("policy_uri %q is not an http or https URI", c.PolicyUri)
+	}
+	for _, contact := range c.Contacts {
+		if _, err := mail.ParseAddress(contact); err != nil {
+			return invalidClientMetadata("the contact %q is not an e-mail address", contact)
+		}
+	}
+	return nil
+}
+
+// AllowsGrantType reports whether the client may use the grant type. Clients without GrantTypes may use all grant types,
+// as far as their other settings, like AllowPasswordGrant, allow it.
+func (c Client) AllowsGrantType(grantType string) bool {
+	return len(c.GrantTypes) == 0 || slices.Contains(c.GrantTypes, grantType)
+}
+
+// AllowsResponseType reports whether the client may use the response type. Clients without ResponseTypes may use all response types.
+func (c Client) AllowsResponseType(responseType string) bool {
+	return len(c.ResponseTypes) == 0 || slices.Contains(c.ResponseTypes, responseType)
+}
+
+func invalidClientMetadata(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrInvalidClientMetadata}, args...)...)
+}
+
+func isHttpUri(uri string) bool {
+	parsed, err := url.Parse(uri)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func isHttpsUri(uri string) bool {
+	parsed, err := url.Parse(uri)
+	return err == nil && parsed.Scheme == "https" && parsed.Host != ""
+}
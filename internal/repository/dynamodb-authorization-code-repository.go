@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"time"
+)
+
+type authorizationCode struct {
+	Code                string   `dynamodbav:"code"`
+	ClientId            string   `dynamodbav:"clientId"`
+	Subject             string   `dynamodbav:"subject"`
+	RedirectUri         string   `dynamodbav:"redirectUri"`
+	Scope               string   `dynamodbav:"scope"`
+	Audiences           []string `dynamodbav:"audiences,stringset,omitempty"`
+	CodeChallenge       string   `dynamodbav:"codeChallenge"`
+	CodeChallengeMethod string   `dynamodbav:"codeChallengeMethod"`
+	Nonce               string   `dynamodbav:"nonce,omitempty"`
+	AuthTime            int64    `dynamodbav:"authTime"`
+	Acr                 string   `dynamodbav:"acr,omitempty"`
+	// ExpiresAt is stored as epoch seconds, so that it can be used as the TTL attribute of the table.
+	ExpiresAt int64 `dynamodbav:"expiresAt"`
+}
+
+type DynamoDbAuthorizationCodeRepository struct {
+	client *dynamodb.Client
+}
+
+func (r *DynamoDbAuthorizationCodeRepository) SaveAuthorizationCode(code AuthorizationCode) error {
+	av, err := attributevalue.MarshalMap(authorizationCode{
+		Code:                code.Code,
+		ClientId:            code.ClientId,
+		Subject:             code.Subject,
+		RedirectUri:         code.RedirectUri,
+		Scope:               code.Scope,
+		Audiences:           code.Audiences,
+		CodeChallenge:       code.CodeChallenge,
+		CodeChallengeMethod: code.CodeChallengeMethod,
+		Nonce:               code.Nonce,
+		AuthTime:            code.AuthTime.Unix(),
+		Acr:                 code.Acr,
+		ExpiresAt:           code.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("authorization_codes"),
+		Item:      av,
+	})
+	return err
+}
+
+func (r *DynamoDbAuthorizationCodeRepository) GetAuthorizationCode(code string) (*AuthorizationCode, error) {
+	item, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("authorization_codes"),
+		Key: map[string]types.AttributeValue{
+			"code": &types.AttributeValueMemberS{Value: code},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(item.Item) == 0 {
+		return nil, AuthorizationCodeNotFound{}
+	}
+
+	return toAuthorizationCode(item.Item)
+}
+
+func (r *DynamoDbAuthorizationCodeRepository) ConsumeAuthorizationCode(code string) (*AuthorizationCode, error) {
+	// Deleting the item and returning its old attributes in one call guarantees that
+	// concurrent redemptions of the same code can not both succeed.
+	item, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("authorization_codes"),
+		Key: map[string]types.AttributeValue{
+			"code": &types.AttributeValueMemberS{Value: code},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(item.Attributes) == 0 {
+		return nil, AuthorizationCodeNotFound{}
+	}
+
+	return toAuthorizationCode(item.Attributes)
+}
+
+func toAuthorizationCode(attributes map[string]types.AttributeValue) (*AuthorizationCode, error) {
+	var authorizationCode authorizationCode
+	err := attributevalue.UnmarshalMap(attributes, &authorizationCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthorizationCode{
+		Code:                authorizationCode.Code,
+		ClientId:            authorizationCode.ClientId,
+		Subject:             authorizationCode.Subject,
+		RedirectUri:         authorizationCode.RedirectUri,
+		Scope:               authorizationCode.Scope,
+		Audiences:           authorizationCode.Audiences,
+		CodeChallenge:       authorizationCode.CodeChallenge,
+		CodeChallengeMethod: authorizationCode.CodeChallengeMethod,
+		Nonce:               authorizationCode.Nonce,
+		AuthTime:            time.Unix(authorizationCode.AuthTime, 0),
+		Acr:                 authorizationCode.Acr,
+		ExpiresAt:           time.Unix(authorizationCode.ExpiresAt, 0),
+	}, nil
+}
+
+func NewDynamoDbAuthorizationCodeRepository(client *dynamodb.Client) *DynamoDbAuthorizationCodeRepository {
+	return &DynamoDbAuthorizationCodeRepository{
+		client: client,
+	}
+}
@@ -2,28 +2,95 @@ package repository
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type client struct {
-	ClientId     string `dynamodbav:"clientId"`
-	ClientSecret string `dynamodbav:"clientSecret"`
+	// SchemaVersion is missing in items that were saved before the client schema was versioned.
+	SchemaVersion int    `dynamodbav:"schemaVersion,omitempty"`
+	ClientId      string `dynamodbav:"clientId"`
+	Name          string `dynamodbav:"name,omitempty"`
+	// ClientSecret is the plaintext secret of clients that were saved before secrets were hashed.
+	// It is replaced by a hashed secret when the client authenticates the next time.
+	ClientSecret            string         `dynamodbav:"clientSecret,omitempty"`
+	Secrets                 []clientSecret `dynamodbav:"secrets,omitempty"`
+	TokenEndpointAuthMethod string         `dynamodbav:"tokenEndpointAuthMethod,omitempty"`
+	Jwks                    string         `dynamodbav:"jwks,omitempty"`
+	JwksUri                 string         `dynamodbav:"jwksUri,omitempty"`
+	// RedirectUris, GrantTypes, ResponseTypes, Scopes and Audiences are lists rather than sets, so that their order
+	// is kept for the client metadata. Items saved before stored some of them as string sets, which are read the same way.
+	RedirectUris  []string `dynamodbav:"redirectUris,omitempty"`
+	GrantTypes    []string `dynamodbav:"grantTypes,omitempty"`
+	ResponseTypes []string `dynamodbav:"responseTypes,omitempty"`
+	Scopes        []string `dynamodbav:"scopes,omitempty"`
+	Audiences     []string `dynamodbav:"audiences,omitempty"`
+	// The token lifetimes are stored in seconds.
+	AccessTokenLifetime  int64    `dynamodbav:"accessTokenLifetime,omitempty"`
+	RefreshTokenLifetime int64    `dynamodbav:"refreshTokenLifetime,omitempty"`
+	IdTokenLifetime      int64    `dynamodbav:"idTokenLifetime,omitempty"`
+	TokenFormat          string   `dynamodbav:"tokenFormat,omitempty"`
+	AllowIntrospection   bool     `dynamodbav:"allowIntrospection"`
+	AllowPasswordGrant   bool     `dynamodbav:"allowPasswordGrant,omitempty"`
+	AllowImplicitGrant   bool     `dynamodbav:"allowImplicitGrant,omitempty"`
+	Resource             string   `dynamodbav:"resource,omitempty"`
+	Contacts             []string `dynamodbav:"contacts,omitempty"`
+	LogoUri              string   `dynamodbav:"logoUri,omitempty"`
+	PolicyUri            string   `dynamodbav:"policyUri,omitempty"`
+	// CreatedAt, UpdatedAt and DisabledAt are stored as unix timestamps.
+	CreatedAt      int64  `dynamodbav:"createdAt,omitempty"`
+	UpdatedAt      int64  `dynamodbav:"updatedAt,omitempty"`
+	Disabled       bool   `dynamodbav:"disabled,omitempty"`
+	DisabledReason string `dynamodbav:"disabledReason,omitempty"`
+	DisabledAt     int64  `dynamodbav:"disabledAt,omitempty"`
 }
 
+// updatableClientAttributes are the attributes that UpdateClient replaces.
+var updatableClientAttributes = []string{
+	"schemaVersion", "name", "tokenEndpointAuthMethod", "jwks", "jwksUri",
+	"redirectUris", "grantTypes", "responseTypes", "scopes", "audiences",
+	"accessTokenLifetime", "refreshTokenLifetime", "idTokenLifetime", "tokenFormat",
+	"allowIntrospection", "allowPasswordGrant", "allowImplicitGrant", "resource",
+	"contacts", "logoUri", "policyUri", "updatedAt",
+}
+
+// clientSecret is an item of the secrets list of a client. The times are stored as unix timestamps.
+type clientSecret struct {
+	SecretId  string `dynamodbav:"secretId"`
+	Hash      string `dynamodbav:"hash"`
+	CreatedAt int64  `dynamodbav:"createdAt"`
+	ExpiresAt int64  `dynamodbav:"expiresAt,omitempty"`
+}
+
+// DynamoDbClientRepository stores clients in the clients table.
+// Client secrets are stored as argon2id hash only.
 type DynamoDbClientRepository struct {
 	client *dynamodb.Client
 }
 
-func (r *DynamoDbClientRepository) SaveClient(clientId string, clientSecret string) (*Client, error) {
-	client := client{
-		ClientId:     clientId,
-		ClientSecret: clientSecret,
+func (r *DynamoDbClientRepository) SaveClient(c Client) (*Client, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
 	}
+	secrets, err := clientSecrets(c)
+	if err != nil {
+		return nil, err
+	}
+	stampClient(&c, time.Now())
+
+	client := toClientItem(c)
+	client.Secrets = toClientSecretItems(secrets)
 
 	av, err := attributevalue.MarshalMap(client)
 	if err != nil {
@@ -39,23 +106,328 @@ func (r *DynamoDbClientRepository) SaveClient(clientId string, clientSecret stri
 		return nil, err
 	}
 
-	return &Client{
-		ClientId:     client.ClientId,
-		ClientSecret: client.ClientSecret,
-	}, nil
+	return client.toClient()
 }
 
 func (r *DynamoDbClientRepository) GetClient(clientId string) (*Client, error) {
-	item, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+	client, err := r.getClient(clientId)
+	if err != nil {
+		return nil, err
+	}
+	return client.toClient()
+}
+
+// VerifyClientSecret compares the secret with the unexpired secrets of the client.
+// A legacy plaintext secret is compared in constant time as well, so that secrets added before the client was migrated
+// are accepted too, and it is replaced by a hashed secret if it matches.
+func (r *DynamoDbClientRepository) VerifyClientSecret(clientId string, clientSecret string, now time.Time) (bool, error) {
+	client, err := r.getClient(clientId)
+	if err != nil {
+		return false, err
+	}
+
+	secrets := client.toSecrets()
+	if client.ClientSecret == "" || client.TokenEndpointAuthMethod == AuthMethodNone {
+		return verifyClientSecret(client.TokenEndpointAuthMethod, secrets, clientSecret, now)
+	}
+	if clientSecret == "" {
+		return false, ErrInvalidClientSecret
+	}
+
+	hashed := matchClientSecret(secrets, clientSecret, now)
+	if subtle.ConstantTimeCompare([]byte(client.ClientSecret), []byte(clientSecret)) != 1 {
+		return hashed, nil
+	}
+	return true, r.rehashClientSecret(clientId, client.ClientSecret)
+}
+
+// rehashClientSecret replaces the legacy plaintext secret of the client by a hashed secret,
+// unless the secret was changed in the meantime.
+func (r *DynamoDbClientRepository) rehashClientSecret(clientId string, plaintext string) error {
+	newSecret, err := newClientSecret(plaintext, time.Time{})
+	if err != nil {
+		return err
+	}
+	secrets, err := attributevalue.Marshal(toClientSecretItems([]Secret{*newSecret}))
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("clients"),
+		Key:                 clientKey(clientId),
+		UpdateExpression:    aws.String("SET secrets = list_append(if_not_exists(secrets, :empty), :secrets) REMOVE clientSecret"),
+		ConditionExpression: aws.String("clientSecret = :plaintext"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":secrets":   secrets,
+			":empty":     &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			":plaintext": &types.AttributeValueMemberS{Value: plaintext},
+		},
+	})
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return nil
+	}
+	return err
+}
+
+func (r *DynamoDbClientRepository) AddClientSecret(clientId string, clientSecret string, expiresAt time.Time) (*Secret, error) {
+	newSecret, err := newClientSecret(clientSecret, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := attributevalue.Marshal(toClientSecretItems([]Secret{*newSecret}))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("clients"),
+		Key:                 clientKey(clientId),
+		UpdateExpression:    aws.String("SET secrets = list_append(if_not_exists(secrets, :empty), :secrets)"),
+		ConditionExpression: aws.String("attribute_exists(clientId) AND (attribute_not_exists(tokenEndpointAuthMethod) OR tokenEndpointAuthMethod <> :none)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":secrets": secrets,
+			":empty":   &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			":none":    &types.AttributeValueMemberS{Value: AuthMethodNone},
+		},
+	})
+	if err != nil {
+		return nil, r.conditionError(clientId, err, errPublicClientSecret)
+	}
+	return newSecret, nil
+}
+
+// RetireClientSecret removes the secret by its position in the list,
+// on the condition that no other update has moved it in the meantime.
+func (r *DynamoDbClientRepository) RetireClientSecret(clientId string, secretId string) error {
+	client, err := r.getClient(clientId)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(client.Secrets, func(s clientSecret) bool { return s.SecretId == secretId })
+	if index < 0 {
+		return ErrClientSecretNotFound
+	}
+	if len(client.Secrets) == 1 && client.ClientSecret == "" {
+		return ErrLastClientSecret
+	}
+
+	// The condition keeps a concurrent retirement from removing the last secret,
+	// unless the client still has its legacy plaintext secret.
+	path := fmt.Sprintf("secrets[%d]", index)
+	_, err = r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("clients"),
+		Key:                 clientKey(clientId),
+		UpdateExpression:    aws.String("REMOVE " + path),
+		ConditionExpression: aws.String(path + ".secretId = :secretId AND (size(secrets) > :one OR attribute_exists(clientSecret))"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":secretId": &types.AttributeValueMemberS{Value: secretId},
+			":one":      &types.AttributeValueMemberN{Value: "1"},
+		},
+	})
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		client, err := r.getClient(clientId)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(client.Secrets, func(s clientSecret) bool { return s.SecretId == secretId }) && len(client.Secrets) == 1 && client.ClientSecret == "" {
+			return ErrLastClientSecret
+		}
+		return ErrClientSecretNotFound
+	}
+	return err
+}
+
+func clientKey(clientId string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"clientId": &types.AttributeValueMemberS{Value: clientId},
+	}
+}
+
+func (r *DynamoDbClientRepository) ListClients(limit int, pageToken string) ([]Client, string, error) {
+	input := &dynamodb.ScanInput{
 		TableName: aws.String("clients"),
-		Key: map[string]types.AttributeValue{
-			"clientId": &types.AttributeValueMemberS{Value: clientId},
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+	if pageToken != "" {
+		input.ExclusiveStartKey = clientKey(pageToken)
+	}
+
+	output, err := r.client.Scan(context.TODO(), input)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []client
+	if err := attributevalue.UnmarshalListOfMaps(output.Items, &items); err != nil {
+		return nil, "", err
+	}
+	clients := make([]Client, 0, len(items))
+	for _, item := range items {
+		c, err := item.toClient()
+		// Clients of a newer schema are skipped, so that one of them does not hide all other clients of the page.
+		if errors.Is(err, ErrUnsupportedClientSchema) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		clients = append(clients, *c)
+	}
+
+	nextPageToken := ""
+	if key, ok := output.LastEvaluatedKey["clientId"].(*types.AttributeValueMemberS); ok {
+		nextPageToken = key.Value
+	}
+	return clients, nextPageToken, nil
+}
+
+// UpdateClient sets the updatable attributes of the client and removes those without value,
+// so that concurrent changes of the secrets are not lost.
+// The client is validated together with its stored secrets. A client that becomes public is only updated
+// on the condition that it still has no secrets. Clients of a newer schema version are rejected with
+// ErrUnsupportedClientSchema, so that they are not downgraded and their unknown attributes are kept.
+func (r *DynamoDbClientRepository) UpdateClient(c Client) (*Client, error) {
+	existing, err := r.getClient(c.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if err := existing.checkSchemaVersion(); err != nil {
+		return nil, err
+	}
+	merged := c
+	merged.ClientSecret = existing.ClientSecret
+	merged.Secrets = existing.toSecrets()
+	if err := merged.Validate(); err != nil {
+		return nil, err
+	}
+	stampClient(&c, time.Now())
+	item, err := attributevalue.MarshalMap(toClientItem(c))
+	if err != nil {
+		return nil, err
+	}
+
+	var set, remove []string
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	for _, attribute := range updatableClientAttributes {
+		names["#"+attribute] = attribute
+		if value, ok := item[attribute]; ok {
+			set = append(set, "#"+attribute+" = :"+attribute)
+			values[":"+attribute] = value
+		} else {
+			remove = append(remove, "#"+attribute)
+		}
+	}
+	update := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		update += " REMOVE " + strings.Join(remove, ", ")
+	}
+	condition := "attribute_exists(clientId) AND (attribute_not_exists(#schemaVersion) OR #schemaVersion <= :currentSchemaVersion)"
+	values[":currentSchemaVersion"] = &types.AttributeValueMemberN{Value: strconv.Itoa(ClientSchemaVersion)}
+	if c.TokenEndpointAuthMethod == AuthMethodNone {
+		condition += " AND (attribute_not_exists(secrets) OR size(secrets) = :zero) AND attribute_not_exists(clientSecret)"
+		values[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+	}
+
+	output, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String("clients"),
+		Key:                       clientKey(c.ClientId),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return nil, r.conditionError(c.ClientId, err, errPublicClientSecret)
+	}
+
+	var updated client
+	if err := attributevalue.UnmarshalMap(output.Attributes, &updated); err != nil {
+		return nil, err
+	}
+	return updated.toClient()
+}
+
+func (r *DynamoDbClientRepository) DisableClient(clientId string, reason string) error {
+	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("clients"),
+		Key:                 clientKey(clientId),
+		UpdateExpression:    aws.String("SET disabled = :disabled, disabledReason = :reason, disabledAt = :disabledAt"),
+		ConditionExpression: aws.String("attribute_exists(clientId)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":disabled":   &types.AttributeValueMemberBOOL{Value: true},
+			":reason":     &types.AttributeValueMemberS{Value: reason},
+			":disabledAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
 		},
 	})
+	return clientError(err)
+}
+
+func (r *DynamoDbClientRepository) EnableClient(clientId string) error {
+	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("clients"),
+		Key:                 clientKey(clientId),
+		UpdateExpression:    aws.String("REMOVE disabled, disabledReason, disabledAt"),
+		ConditionExpression: aws.String("attribute_exists(clientId)"),
+	})
+	return clientError(err)
+}
+
+func (r *DynamoDbClientRepository) DeleteClient(clientId string) error {
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName:           aws.String("clients"),
+		Key:                 clientKey(clientId),
+		ConditionExpression: aws.String("attribute_exists(clientId)"),
+	})
+	return clientError(err)
+}
+
+// clientError maps the failed attribute_exists condition of a write to ErrClientNotFound.
+func clientError(err error) error {
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return ErrClientNotFound
+	}
+	return err
+}
+
+// conditionError maps a failed condition of a write, which also depends on the secrets of the client,
+// to ErrClientNotFound if the client does not exist, and to the error of the other condition otherwise.
+func (r *DynamoDbClientRepository) conditionError(clientId string, err error, otherCondition error) error {
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionalCheckFailed) {
+		return err
+	}
+	client, err := r.getClient(clientId)
+	if err != nil {
+		return err
+	}
+	if err := client.checkSchemaVersion(); err != nil {
+		return err
+	}
+	return otherCondition
+}
+
+func (r *DynamoDbClientRepository) getClient(clientId string) (*client, error) {
+	// Reads are strongly consistent, so that a disabled client or a revoked secret takes effect immediately.
+	item, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:      aws.String("clients"),
+		Key:            clientKey(clientId),
+		ConsistentRead: aws.Bool(true),
+	})
 
 	if err != nil {
 		return nil, err
 	}
+	if len(item.Item) == 0 {
+		return nil, ErrClientNotFound
+	}
 
 	var client client
 
@@ -65,12 +437,131 @@ func (r *DynamoDbClientRepository) GetClient(clientId string) (*Client, error) {
 		return nil, err
 	}
 
+	return &client, nil
+}
+
+// toClientItem converts the settings and the disabled state of the client to an item without secrets.
+func toClientItem(c Client) client {
+	return client{
+		SchemaVersion:           c.SchemaVersion,
+		ClientId:                c.ClientId,
+		Name:                    c.Name,
+		TokenEndpointAuthMethod: c.TokenEndpointAuthMethod,
+		Jwks:                    c.Jwks,
+		JwksUri:                 c.JwksUri,
+		RedirectUris:            c.RedirectUris,
+		GrantTypes:              c.GrantTypes,
+		ResponseTypes:           c.ResponseTypes,
+		Scopes:                  c.Scopes,
+		Audiences:               c.Audiences,
+		AccessTokenLifetime:     int64(c.AccessTokenLifetime / time.Second),
+		RefreshTokenLifetime:    int64(c.RefreshTokenLifetime / time.Second),
+		IdTokenLifetime:         int64(c.IdTokenLifetime / time.Second),
+		TokenFormat:             c.TokenFormat,
+		AllowIntrospection:      c.AllowIntrospection,
+		AllowPasswordGrant:      c.AllowPasswordGrant,
+		AllowImplicitGrant:      c.AllowImplicitGrant,
+		Resource:                c.Resource,
+		Contacts:                c.Contacts,
+		LogoUri:                 c.LogoUri,
+		PolicyUri:               c.PolicyUri,
+		CreatedAt:               toUnix(c.CreatedAt),
+		UpdatedAt:               toUnix(c.UpdatedAt),
+		Disabled:                c.Disabled,
+		DisabledReason:          c.DisabledReason,
+		DisabledAt:              toUnix(c.DisabledAt),
+	}
+}
+
+// checkSchemaVersion rejects items of a newer schema version with ErrUnsupportedClientSchema,
+// because their meaning may have changed.
+func (c client) checkSchemaVersion() error {
+	if c.SchemaVersion > ClientSchemaVersion {
+		return fmt.Errorf("%w: client %s has version %d", ErrUnsupportedClientSchema, c.ClientId, c.SchemaVersion)
+	}
+	return nil
+}
+
+// toClient converts the item to a Client, which never contains the plaintext secret.
+// Items of a newer schema version are rejected, because their meaning may have changed.
+func (c client) toClient() (*Client, error) {
+	if err := c.checkSchemaVersion(); err != nil {
+		return nil, err
+	}
 	return &Client{
-		ClientId:     client.ClientId,
-		ClientSecret: client.ClientSecret,
+		SchemaVersion:           c.SchemaVersion,
+		ClientId:                c.ClientId,
+		Name:                    c.Name,
+		Secrets:                 c.toSecrets(),
+		TokenEndpointAuthMethod: c.TokenEndpointAuthMethod,
+		Jwks:                    c.Jwks,
+		JwksUri:                 c.JwksUri,
+		RedirectUris:            c.RedirectUris,
+		GrantTypes:              c.GrantTypes,
+		ResponseTypes:           c.ResponseTypes,
+		Scopes:                  c.Scopes,
+		Audiences:               c.Audiences,
+		AccessTokenLifetime:     time.Duration(c.AccessTokenLifetime) * time.Second,
+		RefreshTokenLifetime:    time.Duration(c.RefreshTokenLifetime) * time.Second,
+		IdTokenLifetime:         time.Duration(c.IdTokenLifetime) * time.Second,
+		TokenFormat:             c.TokenFormat,
+		AllowIntrospection:      c.AllowIntrospection,
+		AllowPasswordGrant:      c.AllowPasswordGrant,
+		AllowImplicitGrant:      c.AllowImplicitGrant,
+		Resource:                c.Resource,
+		Contacts:                c.Contacts,
+		LogoUri:                 c.LogoUri,
+		PolicyUri:               c.PolicyUri,
+		CreatedAt:               fromUnix(c.CreatedAt),
+		UpdatedAt:               fromUnix(c.UpdatedAt),
+		Disabled:                c.Disabled,
+		DisabledReason:          c.DisabledReason,
+		DisabledAt:              fromUnix(c.DisabledAt),
 	}, nil
 }
 
+// toUnix converts the time to a unix timestamp, which is 0 for the zero time, so that the attribute is omitted.
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func fromUnix(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0)
+}
+
+func (c client) toSecrets() []Secret {
+	if len(c.Secrets) == 0 {
+		return nil
+	}
+	secrets := make([]Secret, 0, len(c.Secrets))
+	for _, s := range c.Secrets {
+		result := Secret{SecretId: s.SecretId, Hash: s.Hash, CreatedAt: time.Unix(s.CreatedAt, 0)}
+		if s.ExpiresAt != 0 {
+			result.ExpiresAt = time.Unix(s.ExpiresAt, 0)
+		}
+		secrets = append(secrets, result)
+	}
+	return secrets
+}
+
+func toClientSecretItems(secrets []Secret) []clientSecret {
+	items := make([]clientSecret, 0, len(secrets))
+	for _, s := range secrets {
+		item := clientSecret{SecretId: s.SecretId, Hash: s.Hash, CreatedAt: s.CreatedAt.Unix()}
+		if !s.ExpiresAt.IsZero() {
+			item.ExpiresAt = s.ExpiresAt.Unix()
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
 func NewDynamoDbClientRepository(client *dynamodb.Client) *DynamoDbClientRepository {
 	return &DynamoDbClientRepository{
 		client: client,
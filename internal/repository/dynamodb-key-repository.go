@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strconv"
+	"time"
+)
+
+type signingKey struct {
+	KeyId     string `dynamodbav:"keyId"`
+	Algorithm string `dynamodbav:"algorithm"`
+	Material  []byte `dynamodbav:"material"`
+	CreatedAt int64  `dynamodbav:"createdAt"`
+	RetiredAt int64  `dynamodbav:"retiredAt,omitempty"`
+	// ExpiresAt is the TTL attribute of the table, so retired keys are removed after their grace period.
+	ExpiresAt        int64  `dynamodbav:"expiresAt,omitempty"`
+	EncryptedDataKey []byte `dynamodbav:"encryptedDataKey,omitempty"`
+}
+
+type DynamoDbKeyRepository struct {
+	client *dynamodb.Client
+}
+
+func (r *DynamoDbKeyRepository) SaveKey(key SigningKey) error {
+	item := signingKey{
+		KeyId:            key.KeyId,
+		Algorithm:        key.Algorithm,
+		Material:         key.Material,
+		CreatedAt:        key.CreatedAt.Unix(),
+		EncryptedDataKey: key.EncryptedDataKey,
+	}
+	if !key.RetiredAt.IsZero() {
+		item.RetiredAt = key.RetiredAt.Unix()
+		item.ExpiresAt = key.ExpiresAt.Unix()
+	}
+
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("signing_keys"),
+		Item:      av,
+	})
+	return err
+}
+
+func (r *DynamoDbKeyRepository) ListKeys() ([]SigningKey, error) {
+	paginator := dynamodb.NewScanPaginator(r.client, &dynamodb.ScanInput{
+		TableName:      aws.String("signing_keys"),
+		ConsistentRead: aws.Bool(true),
+	})
+
+	keys := []SigningKey{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		var items []signingKey
+		err = attributevalue.UnmarshalListOfMaps(page.Items, &items)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			key := SigningKey{
+				KeyId:            item.KeyId,
+				Algorithm:        item.Algorithm,
+				Material:         item.Material,
+				CreatedAt:        time.Unix(item.CreatedAt, 0),
+				EncryptedDataKey: item.EncryptedDataKey,
+			}
+			if item.RetiredAt != 0 {
+				key.RetiredAt = time.Unix(item.RetiredAt, 0)
+				key.ExpiresAt = time.Unix(item.ExpiresAt, 0)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (r *DynamoDbKeyRepository) RetireKey(keyId string, retiredAt time.Time, expiresAt time.Time) error {
+	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String("signing_keys"),
+		Key: map[string]types.AttributeValue{
+			"keyId": &types.AttributeValueMemberS{Value: keyId},
+		},
+		UpdateExpression:    aws.String("SET retiredAt = :retiredAt, expiresAt = :expiresAt"),
+		ConditionExpression: aws.String("attribute_exists(keyId)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":retiredAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(retiredAt.Unix(), 10)},
+			":expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
+		},
+	})
+
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return KeyNotFound{KeyId: keyId}
+	}
+	return err
+}
+
+func NewDynamoDbKeyRepository(client *dynamodb.Client) *DynamoDbKeyRepository {
+	return &DynamoDbKeyRepository{
+		client: client,
+	}
+}
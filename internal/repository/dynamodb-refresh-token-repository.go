@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"time"
+)
+
+type refreshTokenFamily struct {
+	FamilyId  string   `dynamodbav:"familyId"`
+	TokenHash string   `dynamodbav:"tokenHash"`
+	ClientId  string   `dynamodbav:"clientId"`
+	Subject   string   `dynamodbav:"subject"`
+	Scope     string   `dynamodbav:"scope"`
+	Audiences []string `dynamodbav:"audiences,stringset,omitempty"`
+	ExpiresAt int64    `dynamodbav:"expiresAt"`
+	Revoked   bool     `dynamodbav:"revoked"`
+}
+
+type DynamoDbRefreshTokenRepository struct {
+	client *dynamodb.Client
+}
+
+func (r *DynamoDbRefreshTokenRepository) SaveRefreshTokenFamily(family RefreshTokenFamily) error {
+	av, err := attributevalue.MarshalMap(refreshTokenFamily{
+		FamilyId:  family.FamilyId,
+		TokenHash: family.TokenHash,
+		ClientId:  family.ClientId,
+		Subject:   family.Subject,
+		Scope:     family.Scope,
+		Audiences: family.Audiences,
+		ExpiresAt: family.ExpiresAt.Unix(),
+		Revoked:   family.Revoked,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("refresh_tokens"),
+		Item:      av,
+	})
+	return err
+}
+
+func (r *DynamoDbRefreshTokenRepository) GetRefreshTokenFamily(familyId string) (*RefreshTokenFamily, error) {
+	item, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:      aws.String("refresh_tokens"),
+		Key:            refreshTokenFamilyKey(familyId),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(item.Item) == 0 {
+		return nil, RefreshTokenNotFound{}
+	}
+
+	var family refreshTokenFamily
+	err = attributevalue.UnmarshalMap(item.Item, &family)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefreshTokenFamily{
+		FamilyId:  family.FamilyId,
+		TokenHash: family.TokenHash,
+		ClientId:  family.ClientId,
+		Subject:   family.Subject,
+		Scope:     family.Scope,
+		Audiences: family.Audiences,
+		ExpiresAt: time.Unix(family.ExpiresAt, 0),
+		Revoked:   family.Revoked,
+	}, nil
+}
+
+func (r *DynamoDbRefreshTokenRepository) RotateRefreshToken(familyId string, previousTokenHash string, tokenHash string) error {
+	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("refresh_tokens"),
+		Key:                 refreshTokenFamilyKey(familyId),
+		UpdateExpression:    aws.String("SET tokenHash = :tokenHash"),
+		ConditionExpression: aws.String("tokenHash = :previousTokenHash AND revoked = :false"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tokenHash":         &types.AttributeValueMemberS{Value: tokenHash},
+			":previousTokenHash": &types.AttributeValueMemberS{Value: previousTokenHash},
+			":false":             &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return RefreshTokenReused{}
+	}
+	return err
+}
+
+func (r *DynamoDbRefreshTokenRepository) RevokeRefreshTokenFamily(familyId string) error {
+	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("refresh_tokens"),
+		Key:                 refreshTokenFamilyKey(familyId),
+		UpdateExpression:    aws.String("SET revoked = :true"),
+		ConditionExpression: aws.String("attribute_exists(familyId)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+		},
+	})
+
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return RefreshTokenNotFound{}
+	}
+	return err
+}
+
+func refreshTokenFamilyKey(familyId string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"familyId": &types.AttributeValueMemberS{Value: familyId},
+	}
+}
+
+func NewDynamoDbRefreshTokenRepository(client *dynamodb.Client) *DynamoDbRefreshTokenRepository {
+	return &DynamoDbRefreshTokenRepository{
+		client: client,
+	}
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type revokedToken struct {
+	TokenId   string `dynamodbav:"jti"`
+	ClientId  string `dynamodbav:"clientId"`
+	RevokedAt int64  `dynamodbav:"revokedAt"`
+	// ExpiresAt is the TTL attribute of the table, so revocations are removed once the token has expired.
+	ExpiresAt int64 `dynamodbav:"expiresAt"`
+}
+
+type DynamoDbRevocationRepository struct {
+	client *dynamodb.Client
+}
+
+func (r *DynamoDbRevocationRepository) RevokeToken(token RevokedToken) error {
+	av, err := attributevalue.MarshalMap(revokedToken{
+		TokenId:   token.TokenId,
+		ClientId:  token.ClientId,
+		RevokedAt: token.RevokedAt.Unix(),
+		ExpiresAt: token.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("revoked_tokens"),
+		Item:      av,
+	})
+	return err
+}
+
+func (r *DynamoDbRevocationRepository) IsRevoked(tokenId string) (bool, error) {
+	item, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("revoked_tokens"),
+		Key: map[string]types.AttributeValue{
+			"jti": &types.AttributeValueMemberS{Value: tokenId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(item.Item) != 0, nil
+}
+
+func NewDynamoDbRevocationRepository(client *dynamodb.Client) *DynamoDbRevocationRepository {
+	return &DynamoDbRevocationRepository{
+		client: client,
+	}
+}
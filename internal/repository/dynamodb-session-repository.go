@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"time"
+)
+
+type session struct {
+	SessionHash string `dynamodbav:"sessionHash"`
+	Subject     string `dynamodbav:"subject"`
+	AuthTime    int64  `dynamodbav:"authTime"`
+	// ExpiresAt is the TTL attribute of the table, so expired sessions are removed.
+	ExpiresAt int64 `dynamodbav:"expiresAt"`
+}
+
+type DynamoDbSessionRepository struct {
+	client *dynamodb.Client
+}
+
+func (r *DynamoDbSessionRepository) SaveSession(s Session) error {
+	av, err := attributevalue.MarshalMap(session{
+		SessionHash: s.SessionHash,
+		Subject:     s.Subject,
+		AuthTime:    s.AuthTime.Unix(),
+		ExpiresAt:   s.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("sessions"),
+		Item:      av,
+	})
+	return err
+}
+
+func (r *DynamoDbSessionRepository) GetSession(sessionHash string) (*Session, error) {
+	item, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:      aws.String("sessions"),
+		Key:            sessionKey(sessionHash),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(item.Item) == 0 {
+		return nil, SessionNotFound{}
+	}
+
+	var s session
+	err = attributevalue.UnmarshalMap(item.Item, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		SessionHash: s.SessionHash,
+		Subject:     s.Subject,
+		AuthTime:    time.Unix(s.AuthTime, 0),
+		ExpiresAt:   time.Unix(s.ExpiresAt, 0),
+	}, nil
+}
+
+func (r *DynamoDbSessionRepository) DeleteSession(sessionHash string) error {
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("sessions"),
+		Key:       sessionKey(sessionHash),
+	})
+	return err
+}
+
+func sessionKey(sessionHash string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"sessionHash": &types.AttributeValueMemberS{Value: sessionHash},
+	}
+}
+
+func NewDynamoDbSessionRepository(client *dynamodb.Client) *DynamoDbSessionRepository {
+	return &DynamoDbSessionRepository{
+		client: client,
+	}
+}
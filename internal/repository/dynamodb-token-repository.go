@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"time"
+)
+
+type referenceToken struct {
+	TokenHash string `dynamodbav:"tokenHash"`
+	Claims    string `dynamodbav:"claims"`
+	// ExpiresAt is the TTL attribute of the table, so expired tokens are removed.
+	ExpiresAt int64 `dynamodbav:"expiresAt"`
+}
+
+type DynamoDbTokenRepository struct {
+	client *dynamodb.Client
+}
+
+func (r *DynamoDbTokenRepository) SaveToken(token ReferenceToken) error {
+	av, err := attributevalue.MarshalMap(referenceToken{
+		TokenHash: token.TokenHash,
+		Claims:    string(token.Claims),
+		ExpiresAt: token.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("access_tokens"),
+		Item:      av,
+	})
+	return err
+}
+
+func (r *DynamoDbTokenRepository) GetToken(tokenHash string) (*ReferenceToken, error) {
+	item, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("access_tokens"),
+		Key: map[string]types.AttributeValue{
+			"tokenHash": &types.AttributeValueMemberS{Value: tokenHash},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(item.Item) == 0 {
+		return nil, TokenNotFound{}
+	}
+
+	var token referenceToken
+	err = attributevalue.UnmarshalMap(item.Item, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReferenceToken{
+		TokenHash: token.TokenHash,
+		Claims:    []byte(token.Claims),
+		ExpiresAt: time.Unix(token.ExpiresAt, 0),
+	}, nil
+}
+
+func NewDynamoDbTokenRepository(client *dynamodb.Client) *DynamoDbTokenRepository {
+	return &DynamoDbTokenRepository{
+		client: client,
+	}
+}
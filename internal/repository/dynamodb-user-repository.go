@@ -0,0 +1,286 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type user struct {
+	Subject       string            `dynamodbav:"subject"`
+	Username      string            `dynamodbav:"username"`
+	Name          string            `dynamodbav:"name,omitempty"`
+	GivenName     string            `dynamodbav:"givenName,omitempty"`
+	FamilyName    string            `dynamodbav:"familyName,omitempty"`
+	Email         string            `dynamodbav:"email,omitempty"`
+	EmailVerified bool              `dynamodbav:"emailVerified"`
+	PasswordHash  string            `dynamodbav:"passwordHash"`
+	Attributes    map[string]string `dynamodbav:"attributes,omitempty"`
+	FailedLogins  int               `dynamodbav:"failedLogins"`
+	LockedUntil   int64             `dynamodbav:"lockedUntil,omitempty"`
+}
+
+// DynamoDbUserRepository stores users in the users table, whose partition key is the subject.
+// Users are looked up by their username through the username-index global secondary index.
+// Each username is reserved by an item with the subject "username#" followed by the username,
+// which is written in the same transaction as the user, so that two users can not share a username.
+// Subjects with this prefix are therefore rejected, so that a reservation is never read or written as a user.
+type DynamoDbUserRepository struct {
+	client *dynamodb.Client
+}
+
+// ErrReservedSubject is returned by SaveUser for a subject with the prefix of the username reservations.
+var ErrReservedSubject = errors.New("the subject is reserved")
+
+func (r *DynamoDbUserRepository) SaveUser(u User) error {
+	if isReservedSubject(u.Subject) {
+		return ErrReservedSubject
+	}
+
+	item := user{
+		Subject:       u.Subject,
+		Username:      u.Username,
+		Name:          u.Name,
+		GivenName:     u.GivenName,
+		FamilyName:    u.FamilyName,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		PasswordHash:  u.PasswordHash,
+		Attributes:    u.Attributes,
+		FailedLogins:  u.FailedLogins,
+	}
+	if !u.LockedUntil.IsZero() {
+		item.LockedUntil = u.LockedUntil.Unix()
+	}
+
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+
+	previous, err := r.GetUser(u.Subject)
+	var notFound UserNotFound
+	if err != nil && !errors.As(err, &notFound) {
+		return err
+	}
+
+	// The user must not have been renamed since it was read, otherwise its previous username would stay reserved.
+	put := &types.Put{
+		TableName:           aws.String("users"),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(subject)"),
+	}
+	if previous != nil {
+		put.ConditionExpression = aws.String("username = :previousUsername")
+		put.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":previousUsername": &types.AttributeValueMemberS{Value: previous.Username},
+		}
+	}
+
+	subject := &types.AttributeValueMemberS{Value: u.Subject}
+	items := []types.TransactWriteItem{
+		{Put: put},
+		{Put: &types.Put{
+			TableName: aws.String("users"),
+			Item: map[string]types.AttributeValue{
+				"subject":      &types.AttributeValueMemberS{Value: usernameSubject(u.Username)},
+				"ownerSubject": subject,
+			},
+			ConditionExpression:       aws.String("attribute_not_exists(subject) OR ownerSubject = :subject"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":subject": subject},
+		}},
+	}
+	if previous != nil && previous.Username != u.Username {
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String("users"),
+			Key:                       userKey(usernameSubject(previous.Username)),
+			ConditionExpression:       aws.String("attribute_not_exists(subject) OR ownerSubject = :subject"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":subject": subject},
+		}})
+	}
+
+	_, err = r.client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 1 &&
+		aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+func (r *DynamoDbUserRepository) GetUser(subject string) (*User, error) {
+	if isReservedSubject(subject) {
+		return nil, UserNotFound{Subject: subject}
+	}
+
+	item, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:      aws.String("users"),
+		Key:            userKey(subject),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(item.Item) == 0 {
+		return nil, UserNotFound{Subject: subject}
+	}
+
+	var u user
+	err = attributevalue.UnmarshalMap(item.Item, &u)
+	if err != nil {
+		return nil, err
+	}
+	return u.toUser(), nil
+}
+
+func (r *DynamoDbUserRepository) GetUserByUsername(username string) (*User, error) {
+	result, err := r.client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("users"),
+		IndexName:              aws.String("username-index"),
+		KeyConditionExpression: aws.String("username = :username"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":username": &types.AttributeValueMemberS{Value: username},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Items) == 0 {
+		return nil, UserNotFound{}
+	}
+
+	// The index is only eventually consistent, so the user is read from the table to get its current login state.
+	var u user
+	err = attributevalue.UnmarshalMap(result.Items[0], &u)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetUser(u.Subject)
+}
+
+func (r *DynamoDbUserRepository) RecordFailedLogin(subject string) (int, error) {
+	if isReservedSubject(subject) {
+		return 0, UserNotFound{Subject: subject}
+	}
+
+	result, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("users"),
+		Key:                 userKey(subject),
+		UpdateExpression:    aws.String("ADD failedLogins :one"),
+		ConditionExpression: aws.String("attribute_exists(subject)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, userError(subject, err)
+	}
+
+	var updated struct {
+		FailedLogins int `dynamodbav:"failedLogins"`
+	}
+	err = attributevalue.UnmarshalMap(result.Attributes, &updated)
+	if err != nil {
+		return 0, err
+	}
+	return updated.FailedLogins, nil
+}
+
+func (r *DynamoDbUserRepository) LockUser(subject string, lockedUntil time.Time) error {
+	if isReservedSubject(subject) {
+		return UserNotFound{Subject: subject}
+	}
+
+	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("users"),
+		Key:                 userKey(subject),
+		UpdateExpression:    aws.String("SET failedLogins = :zero, lockedUntil = :lockedUntil"),
+		ConditionExpression: aws.String("attribute_exists(subject)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero":        &types.AttributeValueMemberN{Value: "0"},
+			":lockedUntil": &types.AttributeValueMemberN{Value: strconv.FormatInt(lockedUntil.Unix(), 10)},
+		},
+	})
+	return userError(subject, err)
+}
+
+func (r *DynamoDbUserRepository) ResetFailedLogins(subject string) error {
+	if isReservedSubject(subject) {
+		return UserNotFound{Subject: subject}
+	}
+
+	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("users"),
+		Key:                 userKey(subject),
+		UpdateExpression:    aws.String("SET failedLogins = :zero"),
+		ConditionExpression: aws.String("attribute_exists(subject)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		},
+	})
+	return userError(subject, err)
+}
+
+func (u user) toUser() *User {
+	result := &User{
+		Subject:       u.Subject,
+		Username:      u.Username,
+		Name:          u.Name,
+		GivenName:     u.GivenName,
+		FamilyName:    u.FamilyName,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		PasswordHash:  u.PasswordHash,
+		Attributes:    u.Attributes,
+		FailedLogins:  u.FailedLogins,
+	}
+	if u.LockedUntil != 0 {
+		result.LockedUntil = time.Unix(u.LockedUntil, 0)
+	}
+	return result
+}
+
+func userKey(subject string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"subject": &types.AttributeValueMemberS{Value: subject},
+	}
+}
+
+const usernamePrefix = "username#"
+
+// usernameSubject returns the subject of the item, which reserves the username.
+func usernameSubject(username string) string {
+	return usernamePrefix + username
+}
+
+// isReservedSubject reports whether the subject belongs to an item, which reserves a username.
+func isReservedSubject(subject string) bool {
+	return strings.HasPrefix(subject, usernamePrefix)
+}
+
+// userError maps the failed condition of an update to UserNotFound.
+func userError(subject string, err error) error {
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return UserNotFound{Subject: subject}
+	}
+	return err
+}
+
+func NewDynamoDbUserRepository(client *dynamodb.Client) *DynamoDbUserRepository {
+	return &DynamoDbUserRepository{
+		client: client,
+	}
+}
@@ -1,17 +1,291 @@
 package repository
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 type Clock interface {
 	Now() time.Time
 }
 
+// Token formats of the access tokens of a client.
+const (
+	// TokenFormatJwt is a self-contained JWT, which resource servers can verify without calling the server.
+	TokenFormatJwt = "jwt"
+	// TokenFormatOpaque is a random handle, whose claims are only available through the introspection endpoint.
+	TokenFormatOpaque = "opaque"
+)
+
+// Client is the metadata of a registered client, following the client metadata of RFC 7591 section 2 where possible.
+// Its layout is versioned with ClientSchemaVersion, and it is validated with Validate before it is saved.
 type Client struct {
-	ClientId     string
+	// SchemaVersion is the ClientSchemaVersion the client was saved with, or 0 for clients saved before it was introduced.
+	SchemaVersion int
+	ClientId      string
+	// Name is the human-readable name of the client, which is shown to end users.
+	Name string
+	// ClientSecret is the plaintext secret of a client to be saved, which is added to the Secrets without expiry.
+	// The repositories only store its argon2id hash, and never return it.
 	ClientSecret string
+	// Secrets are the secrets of the client. Clients without secrets are public clients.
+	Secrets []Secret
+	// TokenEndpointAuthMethod is the way the client authenticates: client_secret_basic, client_secret_post or none.
+	// If it is empty, clients with secrets may use both client_secret_basic and client_secret_post.
+	TokenEndpointAuthMethod string
+	// Jwks is a JSON Web Key Set document with the public keys of the client. JwksUri references such a document instead.
+	// Only one of them may be set.
+	Jwks         string
+	JwksUri      string
+	RedirectUris []string
+	// GrantTypes are the grant types the client may use at the token endpoint. If empty, the client is not restricted.
+	GrantTypes []string
+	// ResponseTypes are the response types the client may use at the authorization endpoint. If empty, the client is not restricted.
+	ResponseTypes []string
+	// Scopes are the scopes the client may request. Tokens are granted all of them if the client requests no scope.
+	Scopes []string
+	// Audiences are the resource indicators of RFC 8707 the client may request tokens for.
+	// Tokens are issued for all of them if the client requests no resource.
+	Audiences []string
+	// AccessTokenLifetime overrides the default lifetime of the access tokens of the client, if it is not zero.
+	AccessTokenLifetime time.Duration
+	// RefreshTokenLifetime and IdTokenLifetime override the default lifetimes of refresh tokens and ID tokens, if they are not zero.
+	RefreshTokenLifetime time.Duration
+	IdTokenLifetime      time.Duration
+	// TokenFormat is the format of the access tokens of the client, TokenFormatJwt if it is empty.
+	TokenFormat string
+	// AllowIntrospection grants the client, typically a resource server, the right to call the introspection endpoint.
+	AllowIntrospection bool
+	// AllowPasswordGrant allows the client to exchange the username and password of an end user for tokens.
+	// It is meant for first-party clients that can not open a browser, and is disabled by default.
+	AllowPasswordGrant bool
+	// AllowImplicitGrant allows the client to request access tokens with response_type=token at the authorization endpoint.
+	// It has no effect unless the implicit grant is enabled for the server.
+	AllowImplicitGrant bool
+	// Resource is the resource indicator of a client that acts as resource server.
+	// If set, tokens that are introspected by the client are only active if they are issued for this audience.
+	Resource string
+	// Contacts are the e-mail addresses of the people responsible for the client.
+	Contacts []string
+	// LogoUri and PolicyUri reference the logo and the privacy policy of the client, which are shown to end users.
+	LogoUri   string
+	PolicyUri string
+	// CreatedAt and UpdatedAt are set by the repositories when the client is saved or updated.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Disabled clients can not authenticate, and their tokens are no longer active.
+	// A client is disabled with DisableClient, for example when its secret was compromised.
+	Disabled       bool
+	DisabledReason string
+	DisabledAt     time.Time
+}
+
+// Secret is one of the secrets of a client.
+// All secrets that have not expired are accepted, so that a new secret can be rolled out to all consumers of a client
+// before the old one is retired.
+type Secret struct {
+	SecretId string
+	// Hash is the argon2id hash of the secret.
+	Hash      string
+	CreatedAt time.Time
+	// ExpiresAt is the time from which the secret is no longer accepted. Secrets with a zero ExpiresAt do not expire.
+	ExpiresAt time.Time
 }
 
+var (
+	// ErrClientNotFound is returned by all ClientRepository implementations for unknown client ids.
+	ErrClientNotFound = errors.New("client not found")
+	// ErrClientSecretNotFound is returned by RetireClientSecret for unknown secret ids.
+	ErrClientSecretNotFound = errors.New("client secret not found")
+	// ErrLastClientSecret is returned by RetireClientSecret for the last secret of a client,
+	// because a client without secrets could no longer authenticate.
+	ErrLastClientSecret = errors.New("the last client secret can not be retired")
+	// ErrInvalidClientSecret is returned by VerifyClientSecret for an empty secret of a client,
+	// whose token endpoint auth method is not none. Only such explicitly public clients are identified without secret.
+	ErrInvalidClientSecret = errors.New("invalid client secret")
+	// ErrInvalidClientMetadata is returned by SaveClient and UpdateClient for clients that fail Validate.
+	ErrInvalidClientMetadata = errors.New("invalid client metadata")
+	// ErrUnsupportedClientSchema is returned for clients that were saved with a newer ClientSchemaVersion,
+	// for example by a newer version of the server during a deployment.
+	ErrUnsupportedClientSchema = errors.New("unsupported client schema version")
+)
+
 type ClientRepository interface {
-	SaveClient(clientId string, clientSecret string) (*Client, error)
+	// SaveClient validates and saves the client, replacing an existing client with the same id.
+	SaveClient(client Client) (*Client, error)
 	GetClient(clientId string) (*Client, error)
+	// VerifyClientSecret reports whether the secret matches one of the secrets of the client, which have not expired at now.
+	// The secrets are compared in constant time. Only a client with the TokenEndpointAuthMethod none matches the empty secret,
+	// for all other clients the empty secret is rejected with ErrInvalidClientSecret.
+	VerifyClientSecret(clientId string, clientSecret string, now time.Time) (bool, error)
+	// AddClientSecret adds a secret to the client, which expires at expiresAt, or never if expiresAt is zero.
+	// Public clients with the token endpoint auth method none can not have secrets, ErrInvalidClientMetadata is returned for them.
+	AddClientSecret(clientId string, clientSecret string, expiresAt time.Time) (*Secret, error)
+	// RetireClientSecret removes the secret with the id from the client, so that it is no longer accepted.
+	// The last secret of a client can not be retired, ErrLastClientSecret is returned for it.
+	RetireClientSecret(clientId string, secretId string) error
+	// ListClients returns up to limit clients, which follow the pageToken, and the token of the next page.
+	// An empty pageToken returns the first page, an empty next page token is returned with the last page.
+	// A page may contain fewer clients than the limit, even if it is not the last page. A limit of 0 means no limit.
+	// Clients with a newer SchemaVersion, which GetClient rejects with ErrUnsupportedClientSchema, are left out.
+	ListClients(limit int, pageToken string) ([]Client, string, error)
+	// UpdateClient replaces the settings of an existing client.
+	// The secrets, the disabled state and CreatedAt are kept, because they are changed with their own methods.
+	// The client is validated together with the secrets it keeps.
+	UpdateClient(client Client) (*Client, error)
+	// DisableClient disables the client with the reason, until it is enabled again with EnableClient.
+	DisableClient(clientId string, reason string) error
+	EnableClient(clientId string) error
+	// DeleteClient deletes the client with all of its secrets.
+	DeleteClient(clientId string) error
+}
+
+// AuthorizationCode is a short-lived, single-use code issued by the authorization endpoint.
+// It is bound to the client, the redirect URI and the PKCE code challenge of the authorization request.
+type AuthorizationCode struct {
+	Code                string
+	ClientId            string
+	Subject             string
+	RedirectUri         string
+	Scope               string
+	Audiences           []string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce, AuthTime and Acr describe the authentication of the user for the ID token of OpenID Connect.
+	Nonce     string
+	AuthTime  time.Time
+	Acr       string
+	ExpiresAt time.Time
+}
+
+type AuthorizationCodeRepository interface {
+	SaveAuthorizationCode(code AuthorizationCode) error
+	// GetAuthorizationCode returns the code without removing it.
+	GetAuthorizationCode(code string) (*AuthorizationCode, error)
+	// ConsumeAuthorizationCode returns the code and removes it, so that it can only be redeemed once.
+	ConsumeAuthorizationCode(code string) (*AuthorizationCode, error)
+}
+
+// RefreshTokenFamily holds the state of a chain of refresh tokens that originate from the same grant.
+// Only the hash of the most recently issued refresh token is stored, all previously issued tokens of the family
+// are considered used.
+type RefreshTokenFamily struct {
+	FamilyId  string
+	TokenHash string
+	ClientId  string
+	Subject   string
+	Scope     string
+	Audiences []string
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+type RefreshTokenRepository interface {
+	SaveRefreshTokenFamily(family RefreshTokenFamily) error
+	GetRefreshTokenFamily(familyId string) (*RefreshTokenFamily, error)
+	// RotateRefreshToken replaces the token hash of the family, if the family is not revoked
+	// and its current token hash still equals previousTokenHash. Otherwise, it returns RefreshTokenReused.
+	RotateRefreshToken(familyId string, previousTokenHash string, tokenHash string) error
+	RevokeRefreshTokenFamily(familyId string) error
+}
+
+// SigningKey is the persisted form of a token signing key.
+// The active signing key is the most recently created key that is not retired.
+// Retired keys are still used to verify tokens until they expire.
+type SigningKey struct {
+	KeyId     string
+	Algorithm string
+	// Material is the PKCS #8 encoded private key of asymmetric keys or the secret of symmetric keys.
+	Material  []byte
+	CreatedAt time.Time
+	// RetiredAt is the zero time for the active key.
+	RetiredAt time.Time
+	// ExpiresAt is the end of the grace period of a retired key, after which it is no longer used for verification.
+	ExpiresAt time.Time
+	// EncryptedDataKey is the KMS encrypted data key, with which the Material is encrypted.
+	// It is nil if the Material is not encrypted.
+	EncryptedDataKey []byte
+}
+
+type KeyRepository interface {
+	SaveKey(key SigningKey) error
+	ListKeys() ([]SigningKey, error)
+	RetireKey(keyId string, retiredAt time.Time, expiresAt time.Time) error
+}
+
+// RevokedToken records that the token with the jti TokenId was revoked before it expired.
+// The record is only needed until ExpiresAt, because the token is rejected afterwards anyway.
+type RevokedToken struct {
+	TokenId   string
+	ClientId  string
+	RevokedAt time.Time
+	ExpiresAt time.Time
+}
+
+type RevocationRepository interface {
+	RevokeToken(token RevokedToken) error
+	IsRevoked(tokenId string) (bool, error)
+}
+
+// ReferenceToken holds the claims of an opaque access token.
+// Only the hash of the token is stored, so that the tokens can not be used by anyone who can read the store.
+type ReferenceToken struct {
+	TokenHash string
+	// Claims are the JSON encoded claims of the token.
+	Claims    []byte
+	ExpiresAt time.Time
+}
+
+type TokenRepository interface {
+	SaveToken(token ReferenceToken) error
+	GetToken(tokenHash string) (*ReferenceToken, error)
+}
+
+// User is an end user of the identity provider. The profile and email fields are the standard claims of
+// OpenID Connect Core section 5.1, which are returned for the profile and email scopes.
+type User struct {
+	// Subject is the stable identifier of the user, Username is the unique name the user logs in with.
+	Subject       string
+	Username      string
+	Name          string
+	GivenName     string
+	FamilyName    string
+	Email         string
+	EmailVerified bool
+	// PasswordHash is an argon2id hash in the PHC string format, or a bcrypt hash of imported users.
+	PasswordHash string
+	Attributes   map[string]string
+	// FailedLogins counts the failed logins since the last successful login. The user can not log in before LockedUntil.
+	FailedLogins int
+	LockedUntil  time.Time
+}
+
+// ErrUsernameTaken is returned by SaveUser for a username, which already belongs to a user with another subject.
+var ErrUsernameTaken = errors.New("username is already taken")
+
+type UserRepository interface {
+	// SaveUser saves the user, replacing an existing user with the same subject.
+	// Usernames are unique, ErrUsernameTaken is returned if another user already has the username.
+	SaveUser(user User) error
+	GetUser(subject string) (*User, error)
+	GetUserByUsername(username string) (*User, error)
+	// RecordFailedLogin increments the failed logins of the user and returns their new count.
+	RecordFailedLogin(subject string) (int, error)
+	// LockUser prevents logins of the user until lockedUntil and resets the failed logins.
+	LockUser(subject string, lockedUntil time.Time) error
+	ResetFailedLogins(subject string) error
+}
+
+// Session is the login session of a user, which is referenced by the hash of the session cookie.
+type Session struct {
+	SessionHash string
+	Subject     string
+	AuthTime    time.Time
+	ExpiresAt   time.Time
+}
+
+type SessionRepository interface {
+	SaveSession(session Session) error
+	GetSession(sessionHash string) (*Session, error)
+	DeleteSession(sessionHash string) error
 }
@@ -0,0 +1,518 @@
+// Package repositorytest contains conformance tests, which every repository implementation must pass,
+// so that the server behaves the same way with each of them.
+package repositorytest
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/thanhpk/randstr"
+	"time"
+)
+
+// ClientRepositorySuite tests a ClientRepository implementation.
+// Run it with suite.Run(t, &repositorytest.ClientRepositorySuite{NewRepository: ...}).
+// Every test uses new client ids, so that the suite can run against persistent tables.
+type ClientRepositorySuite struct {
+	suite.Suite
+	// NewRepository creates the repository under test.
+	NewRepository func() repository.ClientRepository
+	repository    repository.ClientRepository
+}
+
+func (s *ClientRepositorySuite) SetupTest() {
+	s.repository = s.NewRepository()
+}
+
+// clientId returns a client id, which is not used by any other test.
+func clientId() string {
+	return "conformance-" + randstr.Hex(8)
+}
+
+func (s *ClientRepositorySuite) Test_GetClient_ReturnsSavedClient() {
+	// given a saved client
+	id := clientId()
+	client := repository.Client{
+		ClientId:                id,
+		Name:                    "Example",
+		ClientSecret:            "client_secret",
+		TokenEndpointAuthMethod: repository.AuthMethodClientSecretBasic,
+		JwksUri:                 "https://app.example.com/jwks.json",
+		RedirectUris:            []string{"http://localhost:3000/callback"},
+		GrantTypes:              []string{repository.GrantTypeAuthorizationCode, repository.GrantTypeRefreshToken, repository.GrantTypePassword, repository.GrantTypeImplicit},
+		ResponseTypes:           []string{repository.ResponseTypeCode, repository.ResponseTypeToken},
+		Scopes:                  []string{"read:example"},
+		Audiences:               []string{"https://api.example.com"},
+		AccessTokenLifetime:     5 * time.Minute,
+		RefreshTokenLifetime:    24 * time.Hour,
+		IdTokenLifetime:         10 * time.Minute,
+		TokenFormat:             repository.TokenFormatOpaque,
+		AllowIntrospection:      true,
+		AllowPasswordGrant:      true,
+		AllowImplicitGrant:      true,
+		Resource:                "https://api.example.com",
+		Contacts:                []string{"admin@example.com"},
+		LogoUri:                 "https://app.example.com/logo.png",
+		PolicyUri:               "https://app.example.com/privacy",
+	}
+	saved, err := s.repository.SaveClient(client)
+	assert.NoError(s.T(), err)
+
+	// when getting the client
+	found, err := s.repository.GetClient(id)
+
+	// then all attributes are returned, but not the plaintext secret
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "", saved.ClientSecret)
+	assert.Equal(s.T(), "", found.ClientSecret)
+	assert.Len(s.T(), found.Secrets, 1)
+	assert.NotContains(s.T(), found.Secrets[0].Hash, "client_secret")
+	assert.Equal(s.T(), repository.ClientSchemaVersion, found.SchemaVersion)
+	assert.False(s.T(), found.CreatedAt.IsZero())
+	assert.True(s.T(), found.UpdatedAt.Equal(found.CreatedAt))
+	client.ClientSecret = ""
+	client.SchemaVersion = repository.ClientSchemaVersion
+	found.Secrets = nil
+	found.CreatedAt = time.Time{}
+	found.UpdatedAt = time.Time{}
+	assert.Equal(s.T(), client, *found)
+}
+
+func (s *ClientRepositorySuite) Test_SaveClient_RejectsInvalidMetadata() {
+	// given clients with invalid metadata
+	invalid := map[string]repository.Client{
+		"no client id":              {},
+		"relative redirect URI":     {RedirectUris: []string{"/callback"}},
+		"redirect URI fragment":     {RedirectUris: []string{"https://app.example.com/callback#fragment"}},
+		"unknown grant type":        {GrantTypes: []string{"urn:ietf:params:oauth:grant-type:device_code"}},
+		"unknown response type":     {ResponseTypes: []string{"id_token"}},
+		"code without grant":        {GrantTypes: []string{repository.GrantTypeClientCredentials}, ResponseTypes: []string{repository.ResponseTypeCode}},
+		"code grant without URI":    {GrantTypes: []string{repository.GrantTypeAuthorizationCode}},
+		"password grant not opt-in": {GrantTypes: []string{repository.GrantTypePassword}},
+		"implicit grant not opt-in": {GrantTypes: []string{repository.GrantTypeImplicit}, RedirectUris: []string{"https://app.example.com/callback"}},
+		"unknown token format":      {TokenFormat: "paseto"},
+		"negative lifetime":         {RefreshTokenLifetime: -time.Hour},
+		"unknown auth method":       {TokenEndpointAuthMethod: "private_key_jwt"},
+		"public client with secret": {TokenEndpointAuthMethod: repository.AuthMethodNone, ClientSecret: "client_secret"},
+		"public client credentials": {TokenEndpointAuthMethod: repository.AuthMethodNone, GrantTypes: []string{repository.GrantTypeClientCredentials}},
+		"jwks and jwks_uri":         {Jwks: `{"keys":[]}`, JwksUri: "https://app.example.com/jwks.json"},
+		"jwks without keys":         {Jwks: `{"kty":"RSA"}`},
+		"http jwks_uri":             {JwksUri: "http://app.example.com/jwks.json"},
+		"logo URI without host":     {LogoUri: "logo.png"},
+		"policy URI scheme":         {PolicyUri: "javascript:alert(1)"},
+		"contact":                   {Contacts: []string{"not an address"}},
+	}
+	for name, client := range invalid {
+		if name != "no client id" {
+			client.ClientId = clientId()
+		}
+
+		// when saving the client
+		_, err := s.repository.SaveClient(client)
+
+		// then it is rejected and not saved
+		assert.ErrorIs(s.T(), err, repository.ErrInvalidClientMetadata, name)
+		if client.ClientId != "" {
+			_, err = s.repository.GetClient(client.ClientId)
+			assert.ErrorIs(s.T(), err, repository.ErrClientNotFound, name)
+		}
+	}
+}
+
+func (s *ClientRepositorySuite) Test_SaveClient_AcceptsPublicClientWithJwks() {
+	// given a public client with an inline JSON Web Key Set
+	id := clientId()
+	client := repository.Client{
+		ClientId:                id,
+		TokenEndpointAuthMethod: repository.AuthMethodNone,
+		Jwks:                    `{"keys":[{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`,
+		RedirectUris:            []string{"com.example.app:/callback"},
+		GrantTypes:              []string{repository.GrantTypeAuthorizationCode},
+		ResponseTypes:           []string{repository.ResponseTypeCode},
+	}
+
+	// when saving the client
+	_, err := s.repository.SaveClient(client)
+
+	// then it is saved
+	assert.NoError(s.T(), err)
+	found, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), client.Jwks, found.Jwks)
+	assert.Equal(s.T(), []string{"com.example.app:/callback"}, found.RedirectUris)
+}
+
+func (s *ClientRepositorySuite) Test_UpdateClient_RejectsInvalidMetadata() {
+	// given a saved client
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, Scopes: []string{"read:example"}})
+	assert.NoError(s.T(), err)
+
+	// when updating it with invalid metadata
+	_, err = s.repository.UpdateClient(repository.Client{ClientId: id, TokenFormat: "paseto"})
+
+	// then the update is rejected and the client is unchanged
+	assert.ErrorIs(s.T(), err, repository.ErrInvalidClientMetadata)
+	found, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"read:example"}, found.Scopes)
+}
+
+func (s *ClientRepositorySuite) Test_UpdateClient_RejectsPublicClientWithSecrets() {
+	// given a client with a secret
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "client_secret"})
+	assert.NoError(s.T(), err)
+
+	// when updating it to the token endpoint auth method none
+	_, err = s.repository.UpdateClient(repository.Client{ClientId: id, TokenEndpointAuthMethod: repository.AuthMethodNone})
+
+	// then the update is rejected, because the client keeps its secret
+	assert.ErrorIs(s.T(), err, repository.ErrInvalidClientMetadata)
+	found, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "", found.TokenEndpointAuthMethod)
+}
+
+func (s *ClientRepositorySuite) Test_AddClientSecret_RejectsPublicClient() {
+	// given a client with the token endpoint auth method none
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, TokenEndpointAuthMethod: repository.AuthMethodNone})
+	assert.NoError(s.T(), err)
+
+	// when adding a secret
+	_, err = s.repository.AddClientSecret(id, "client_secret", time.Time{})
+
+	// then it is rejected and the client stays public
+	assert.ErrorIs(s.T(), err, repository.ErrInvalidClientMetadata)
+	found, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), found.Secrets)
+}
+
+func (s *ClientRepositorySuite) Test_UpdateClient_KeepsCreatedAt() {
+	// given a client that was saved a while ago
+	id := clientId()
+	createdAt := time.Unix(1700000000, 0)
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, CreatedAt: createdAt})
+	assert.NoError(s.T(), err)
+
+	// when updating it without creation time
+	updated, err := s.repository.UpdateClient(repository.Client{ClientId: id, Name: "Renamed"})
+
+	// then the creation time is kept, and the update time and schema version are set
+	assert.NoError(s.T(), err)
+	found, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	for _, client := range []*repository.Client{updated, found} {
+		assert.Equal(s.T(), "Renamed", client.Name)
+		assert.True(s.T(), client.CreatedAt.Equal(createdAt))
+		assert.True(s.T(), client.UpdatedAt.After(createdAt))
+		assert.Equal(s.T(), repository.ClientSchemaVersion, client.SchemaVersion)
+	}
+}
+
+func (s *ClientRepositorySuite) Test_UpdateClient_KeepsOrderOfLists() {
+	// given a client
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id})
+	assert.NoError(s.T(), err)
+
+	// when updating it with lists, which are not sorted and contain duplicates
+	client := repository.Client{
+		ClientId:     id,
+		RedirectUris: []string{"https://app.example.com/callback", "http://localhost:3000/callback", "https://app.example.com/callback"},
+		Scopes:       []string{"write:example", "read:example", "write:example"},
+		Audiences:    []string{"https://billing.example.com", "https://api.example.com"},
+	}
+	_, err = s.repository.UpdateClient(client)
+	assert.NoError(s.T(), err)
+
+	// then the lists are returned as they were saved
+	found, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), client.RedirectUris, found.RedirectUris)
+	assert.Equal(s.T(), client.Scopes, found.Scopes)
+	assert.Equal(s.T(), client.Audiences, found.Audiences)
+}
+
+func (s *ClientRepositorySuite) Test_GetClient_ReturnsErrClientNotFound() {
+	// when getting a client that was never saved
+	_, err := s.repository.GetClient(clientId())
+
+	// then ErrClientNotFound is returned
+	assert.ErrorIs(s.T(), err, repository.ErrClientNotFound)
+}
+
+func (s *ClientRepositorySuite) Test_VerifyClientSecret_MatchesOnlyTheSecret() {
+	// given a saved client
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "client_secret"})
+	assert.NoError(s.T(), err)
+
+	// when verifying the secret and a wrong secret
+	for secret, expected := range map[string]bool{"client_secret": true, "wrong_secret": false} {
+		ok, err := s.repository.VerifyClientSecret(id, secret, time.Now())
+
+		// then only the secret matches
+		assert.NoError(s.T(), err)
+		assert.Equal(s.T(), expected, ok, secret)
+	}
+
+	// and no secret is rejected with ErrInvalidClientSecret
+	ok, err := s.repository.VerifyClientSecret(id, "", time.Now())
+	assert.ErrorIs(s.T(), err, repository.ErrInvalidClientSecret)
+	assert.False(s.T(), ok)
+}
+
+func (s *ClientRepositorySuite) Test_VerifyClientSecret_MatchesEmptySecretOfPublicClient() {
+	// given a saved public client
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, TokenEndpointAuthMethod: repository.AuthMethodNone})
+	assert.NoError(s.T(), err)
+
+	// when verifying no secret and a secret
+	empty, err := s.repository.VerifyClientSecret(id, "", time.Now())
+	assert.NoError(s.T(), err)
+	other, err := s.repository.VerifyClientSecret(id, "client_secret", time.Now())
+	assert.NoError(s.T(), err)
+
+	// then only the empty secret matches
+	assert.True(s.T(), empty)
+	assert.False(s.T(), other)
+}
+
+func (s *ClientRepositorySuite) Test_VerifyClientSecret_RejectsEmptySecretOfClientWithoutSecrets() {
+	// given a saved client without secrets, which is not declared public
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id})
+	assert.NoError(s.T(), err)
+
+	// when verifying no secret
+	ok, err := s.repository.VerifyClientSecret(id, "", time.Now())
+
+	// then ErrInvalidClientSecret is returned
+	assert.ErrorIs(s.T(), err, repository.ErrInvalidClientSecret)
+	assert.False(s.T(), ok)
+}
+
+func (s *ClientRepositorySuite) Test_VerifyClientSecret_ReturnsErrClientNotFound() {
+	// when verifying an empty secret of a client that was never saved
+	ok, err := s.repository.VerifyClientSecret(clientId(), "", time.Now())
+
+	// then ErrClientNotFound is returned
+	assert.ErrorIs(s.T(), err, repository.ErrClientNotFound)
+	assert.False(s.T(), ok)
+}
+
+func (s *ClientRepositorySuite) Test_AddClientSecret_AcceptsAllUnexpiredSecrets() {
+	// given a client with a second secret, which expires
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "old_secret"})
+	assert.NoError(s.T(), err)
+	expiresAt := time.Unix(1700000000, 0)
+	added, err := s.repository.AddClientSecret(id, "new_secret", expiresAt)
+	assert.NoError(s.T(), err)
+	assert.NotEmpty(s.T(), added.SecretId)
+	assert.True(s.T(), added.ExpiresAt.Equal(expiresAt))
+
+	// then both secrets are accepted before the second secret expires
+	for _, secret := range []string{"old_secret", "new_secret"} {
+		ok, err := s.repository.VerifyClientSecret(id, secret, expiresAt.Add(-time.Second))
+		assert.NoError(s.T(), err)
+		assert.True(s.T(), ok, secret)
+	}
+
+	// and only the first secret is accepted when the second secret has expired
+	ok, err := s.repository.VerifyClientSecret(id, "new_secret", expiresAt)
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), ok)
+	ok, err = s.repository.VerifyClientSecret(id, "old_secret", expiresAt)
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), ok)
+}
+
+func (s *ClientRepositorySuite) Test_RetireClientSecret_RemovesSecret() {
+	// given a client with two secrets
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "old_secret"})
+	assert.NoError(s.T(), err)
+	added, err := s.repository.AddClientSecret(id, "new_secret", time.Time{})
+	assert.NoError(s.T(), err)
+	client, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+
+	// when retiring the first secret
+	err = s.repository.RetireClientSecret(id, client.Secrets[0].SecretId)
+
+	// then only the second secret remains and is accepted
+	assert.NoError(s.T(), err)
+	client, err = s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), client.Secrets, 1)
+	assert.Equal(s.T(), added.SecretId, client.Secrets[0].SecretId)
+	ok, err := s.repository.VerifyClientSecret(id, "old_secret", time.Now())
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), ok)
+	ok, err = s.repository.VerifyClientSecret(id, "new_secret", time.Now())
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), ok)
+}
+
+func (s *ClientRepositorySuite) Test_RetireClientSecret_KeepsLastSecret() {
+	// given a client with one secret
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "client_secret"})
+	assert.NoError(s.T(), err)
+	client, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+
+	// when retiring the secret
+	err = s.repository.RetireClientSecret(id, client.Secrets[0].SecretId)
+
+	// then ErrLastClientSecret is returned and the client stays confidential
+	assert.ErrorIs(s.T(), err, repository.ErrLastClientSecret)
+	ok, err := s.repository.VerifyClientSecret(id, "client_secret", time.Now())
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), ok)
+	ok, err = s.repository.VerifyClientSecret(id, "", time.Now())
+	assert.ErrorIs(s.T(), err, repository.ErrInvalidClientSecret)
+	assert.False(s.T(), ok)
+}
+
+func (s *ClientRepositorySuite) Test_ClientSecrets_ReturnNotFoundErrors() {
+	// given a saved client
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "client_secret"})
+	assert.NoError(s.T(), err)
+
+	// when changing the secrets of an unknown client, or retiring an unknown secret
+	_, addErr := s.repository.AddClientSecret(clientId(), "new_secret", time.Time{})
+	retireErr := s.repository.RetireClientSecret(clientId(), "unknown")
+	unknownSecretErr := s.repository.RetireClientSecret(id, "unknown")
+
+	// then the not found errors are returned
+	assert.ErrorIs(s.T(), addErr, repository.ErrClientNotFound)
+	assert.ErrorIs(s.T(), retireErr, repository.ErrClientNotFound)
+	assert.ErrorIs(s.T(), unknownSecretErr, repository.ErrClientSecretNotFound)
+}
+
+func (s *ClientRepositorySuite) Test_ListClients_ReturnsAllClientsInPages() {
+	// given three saved clients
+	saved := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		id := clientId()
+		_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "client_secret"})
+		assert.NoError(s.T(), err)
+		saved[id] = true
+	}
+
+	// when listing all clients in pages of two
+	listed := map[string]int{}
+	pageToken := ""
+	for {
+		clients, nextPageToken, err := s.repository.ListClients(2, pageToken)
+		assert.NoError(s.T(), err)
+		assert.LessOrEqual(s.T(), len(clients), 2)
+		for _, client := range clients {
+			listed[client.ClientId]++
+			assert.Equal(s.T(), "", client.ClientSecret)
+		}
+		if nextPageToken == "" {
+			break
+		}
+		pageToken = nextPageToken
+	}
+
+	// then every saved client is listed exactly once
+	for id := range saved {
+		assert.Equal(s.T(), 1, listed[id], id)
+	}
+}
+
+func (s *ClientRepositorySuite) Test_UpdateClient_KeepsSecretsAndDisabledState() {
+	// given a disabled client with a secret
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "client_secret", Scopes: []string{"read:example"}, TokenFormat: repository.TokenFormatOpaque})
+	assert.NoError(s.T(), err)
+	err = s.repository.DisableClient(id, "compromised")
+	assert.NoError(s.T(), err)
+
+	// when updating its settings
+	updated, err := s.repository.UpdateClient(repository.Client{ClientId: id, Scopes: []string{"write:example"}, AllowIntrospection: true})
+
+	// then the settings are replaced, but the secret and the disabled state are kept
+	assert.NoError(s.T(), err)
+	found, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	for _, client := range []*repository.Client{updated, found} {
+		assert.Equal(s.T(), []string{"write:example"}, client.Scopes)
+		assert.True(s.T(), client.AllowIntrospection)
+		assert.Equal(s.T(), "", client.TokenFormat)
+		assert.Len(s.T(), client.Secrets, 1)
+		assert.True(s.T(), client.Disabled)
+		assert.Equal(s.T(), "compromised", client.DisabledReason)
+	}
+	ok, err := s.repository.VerifyClientSecret(id, "client_secret", time.Now())
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), ok)
+}
+
+func (s *ClientRepositorySuite) Test_DisableClient_DisablesUntilEnabled() {
+	// given a saved client
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "client_secret"})
+	assert.NoError(s.T(), err)
+
+	// when disabling the client
+	err = s.repository.DisableClient(id, "compromised")
+
+	// then it is disabled with the reason
+	assert.NoError(s.T(), err)
+	client, err := s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), client.Disabled)
+	assert.Equal(s.T(), "compromised", client.DisabledReason)
+	assert.False(s.T(), client.DisabledAt.IsZero())
+
+	// and enabling it again clears the disabled state
+	err = s.repository.EnableClient(id)
+	assert.NoError(s.T(), err)
+	client, err = s.repository.GetClient(id)
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), client.Disabled)
+	assert.Equal(s.T(), "", client.DisabledReason)
+	assert.True(s.T(), client.DisabledAt.IsZero())
+}
+
+func (s *ClientRepositorySuite) Test_DeleteClient_DeletesClient() {
+	// given a saved client
+	id := clientId()
+	_, err := s.repository.SaveClient(repository.Client{ClientId: id, ClientSecret: "client_secret"})
+	assert.NoError(s.T(), err)
+
+	// when deleting the client
+	err = s.repository.DeleteClient(id)
+
+	// then the client is not found anymore
+	assert.NoError(s.T(), err)
+	_, err = s.repository.GetClient(id)
+	assert.ErrorIs(s.T(), err, repository.ErrClientNotFound)
+}
+
+func (s *ClientRepositorySuite) Test_ClientLifecycle_ReturnsErrClientNotFound() {
+	// when changing a client that was never saved
+	id := clientId()
+	_, updateErr := s.repository.UpdateClient(repository.Client{ClientId: id})
+	disableErr := s.repository.DisableClient(id, "compromised")
+	enableErr := s.repository.EnableClient(id)
+	deleteErr := s.repository.DeleteClient(id)
+
+	// then ErrClientNotFound is returned, and no client is created
+	assert.ErrorIs(s.T(), updateErr, repository.ErrClientNotFound)
+	assert.ErrorIs(s.T(), disableErr, repository.ErrClientNotFound)
+	assert.ErrorIs(s.T(), enableErr, repository.ErrClientNotFound)
+	assert.ErrorIs(s.T(), deleteErr, repository.ErrClientNotFound)
+	_, err := s.repository.GetClient(id)
+	assert.ErrorIs(s.T(), err, repository.ErrClientNotFound)
+}
@@ -0,0 +1,76 @@
+package repositorytest
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/thanhpk/randstr"
+)
+
+// UserRepositorySuite tests a UserRepository implementation.
+// Run it with suite.Run(t, &repositorytest.UserRepositorySuite{NewRepository: ...}).
+// Every test uses new subjects and usernames, so that the suite can run against persistent tables.
+type UserRepositorySuite struct {
+	suite.Suite
+	// NewRepository creates the repository under test.
+	NewRepository func() repository.UserRepository
+	repository    repository.UserRepository
+}
+
+func (s *UserRepositorySuite) SetupTest() {
+	s.repository = s.NewRepository()
+}
+
+// uniqueName returns a subject or username, which is not used by any other test.
+func uniqueName(prefix string) string {
+	return prefix + "-" + randstr.Hex(8)
+}
+
+func (s *UserRepositorySuite) Test_SaveUser_RejectsTakenUsername() {
+	// given a saved user
+	username := uniqueName("alice")
+	err := s.repository.SaveUser(repository.User{Subject: uniqueName("subject"), Username: username})
+	assert.NoError(s.T(), err)
+
+	// when saving another user with the same username
+	other := uniqueName("subject")
+	err = s.repository.SaveUser(repository.User{Subject: other, Username: username})
+
+	// then the username is taken and the other user is not saved
+	assert.ErrorIs(s.T(), err, repository.ErrUsernameTaken)
+	_, err = s.repository.GetUser(other)
+	assert.ErrorAs(s.T(), err, &repository.UserNotFound{})
+}
+
+func (s *UserRepositorySuite) Test_SaveUser_UpdatesUserWithSameUsername() {
+	// given a saved user
+	subject := uniqueName("subject")
+	username := uniqueName("alice")
+	err := s.repository.SaveUser(repository.User{Subject: subject, Username: username})
+	assert.NoError(s.T(), err)
+
+	// when saving the user again with the same username
+	err = s.repository.SaveUser(repository.User{Subject: subject, Username: username, Name: "Alice"})
+
+	// then the user is updated
+	assert.NoError(s.T(), err)
+	saved, err := s.repository.GetUserByUsername(username)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "Alice", saved.Name)
+}
+
+func (s *UserRepositorySuite) Test_SaveUser_ReleasesPreviousUsername() {
+	// given a saved user, which was renamed
+	subject := uniqueName("subject")
+	previous := uniqueName("alice")
+	err := s.repository.SaveUser(repository.User{Subject: subject, Username: previous})
+	assert.NoError(s.T(), err)
+	err = s.repository.SaveUser(repository.User{Subject: subject, Username: uniqueName("alice")})
+	assert.NoError(s.T(), err)
+
+	// when saving another user with the previous username
+	err = s.repository.SaveUser(repository.User{Subject: uniqueName("subject"), Username: previous})
+
+	// then the previous username is available again
+	assert.NoError(s.T(), err)
+}
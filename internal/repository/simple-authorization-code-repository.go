@@ -0,0 +1,48 @@
+package repository
+
+import "sync"
+
+type SimpleAuthorizationCodeRepository struct {
+	mu    sync.Mutex
+	codes map[string]AuthorizationCode
+}
+
+type AuthorizationCodeNotFound struct{}
+
+func (e AuthorizationCodeNotFound) Error() string {
+	return "authorization code not found"
+}
+
+func (r *SimpleAuthorizationCodeRepository) SaveAuthorizationCode(code AuthorizationCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codes[code.Code] = code
+	return nil
+}
+
+func (r *SimpleAuthorizationCodeRepository) GetAuthorizationCode(code string) (*AuthorizationCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	authorizationCode, ok := r.codes[code]
+	if !ok {
+		return nil, AuthorizationCodeNotFound{}
+	}
+	return &authorizationCode, nil
+}
+
+func (r *SimpleAuthorizationCodeRepository) ConsumeAuthorizationCode(code string) (*AuthorizationCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	authorizationCode, ok := r.codes[code]
+	if !ok {
+		return nil, AuthorizationCodeNotFound{}
+	}
+	delete(r.codes, code)
+	return &authorizationCode, nil
+}
+
+func NewSimpleAuthorizationCodeRepository() *SimpleAuthorizationCodeRepository {
+	return &SimpleAuthorizationCodeRepository{
+		codes: map[string]AuthorizationCode{},
+	}
+}
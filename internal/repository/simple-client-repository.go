@@ -1,34 +1,255 @@
 package repository
 
-type SimpleClientRepository struct{}
+import (
+	"github.com/daschaa/open-idp/internal/secret"
+	"github.com/thanhpk/randstr"
+	"slices"
+	"sync"
+	"time"
+)
 
-type ClientNotFound struct {
-	ClientId string
+// SimpleClientRepository keeps clients in memory.
+type SimpleClientRepository struct {
+	mu      sync.Mutex
+	clients map[string]Client
 }
 
-func (e ClientNotFound) Error() string {
-	return "client not found"
+func (r *SimpleClientRepository) GetClient(clientId string) (*Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[clientId]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	client.Secrets = slices.Clone(client.Secrets)
+	return &client, nil
+}
+
+func (r *SimpleClientRepository) SaveClient(client Client) (*Client, error) {
+	if err := client.Validate(); err != nil {
+		return nil, err
+	}
+	secrets, err := clientSecrets(client)
+	if err != nil {
+		return nil, err
+	}
+	client.ClientSecret = ""
+	client.Secrets = secrets
+	stampClient(&client, time.Now())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[client.ClientId] = client
+	return &client, nil
+}
+
+func (r *SimpleClientRepository) VerifyClientSecret(clientId string, clientSecret string, now time.Time) (bool, error) {
+	client, err := r.GetClient(clientId)
+	if err != nil {
+		return false, err
+	}
+	return verifyClientSecret(client.TokenEndpointAuthMethod, client.Secrets, clientSecret, now)
+}
+
+func (r *SimpleClientRepository) AddClientSecret(clientId string, clientSecret string, expiresAt time.Time) (*Secret, error) {
+	newSecret, err := newClientSecret(clientSecret, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[clientId]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	if client.TokenEndpointAuthMethod == AuthMethodNone {
+		return nil, errPublicClientSecret
+	}
+	client.Secrets = append(slices.Clone(client.Secrets), *newSecret)
+	r.clients[clientId] = client
+	return newSecret, nil
 }
 
-func (r SimpleClientRepository) GetClient(clientId string) (*Client, error) {
-	if clientId != "1234567890" {
-		return nil, ClientNotFound{
-			ClientId: clientId,
+func (r *SimpleClientRepository) RetireClientSecret(clientId string, secretId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[clientId]
+	if !ok {
+		return ErrClientNotFound
+	}
+	index := slices.IndexFunc(client.Secrets, func(s Secret) bool { return s.SecretId == secretId })
+	if index < 0 {
+		return ErrClientSecretNotFound
+	}
+	if len(client.Secrets) == 1 {
+		return ErrLastClientSecret
+	}
+	client.Secrets = slices.Delete(slices.Clone(client.Secrets), index, index+1)
+	r.clients[clientId] = client
+	return nil
+}
+
+// ListClients returns the clients ordered by their id. The page token is the id of the last client of the previous page.
+func (r *SimpleClientRepository) ListClients(limit int, pageToken string) ([]Client, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clientIds := make([]string, 0, len(r.clients))
+	for clientId := range r.clients {
+		if clientId > pageToken {
+			clientIds = append(clientIds, clientId)
 		}
 	}
-	return &Client{
-		ClientId:     "1234567890",
-		ClientSecret: "client_secret",
-	}, nil
+	slices.Sort(clientIds)
+
+	nextPageToken := ""
+	if limit > 0 && len(clientIds) > limit {
+		clientIds = clientIds[:limit]
+		nextPageToken = clientIds[limit-1]
+	}
+	clients := make([]Client, 0, len(clientIds))
+	for _, clientId := range clientIds {
+		client := r.clients[clientId]
+		client.Secrets = slices.Clone(client.Secrets)
+		clients = append(clients, client)
+	}
+	return clients, nextPageToken, nil
+}
+
+// UpdateClient validates the client together with the secrets it keeps, so that a client with secrets can not become public.
+func (r *SimpleClientRepository) UpdateClient(client Client) (*Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing, ok := r.clients[client.ClientId]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	client.ClientSecret = ""
+	client.Secrets = existing.Secrets
+	client.Disabled = existing.Disabled
+	client.DisabledReason = existing.DisabledReason
+	client.DisabledAt = existing.DisabledAt
+	client.CreatedAt = existing.CreatedAt
+	if err := client.Validate(); err != nil {
+		return nil, err
+	}
+	stampClient(&client, time.Now())
+	r.clients[client.ClientId] = client
+	client.Secrets = slices.Clone(client.Secrets)
+	return &client, nil
 }
 
-func (r SimpleClientRepository) SaveClient(clientId string, clientString string) (*Client, error) {
-	return &Client{
-		ClientId:     "1234567890",
-		ClientSecret: "client_secret",
+func (r *SimpleClientRepository) DisableClient(clientId string, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[clientId]
+	if !ok {
+		return ErrClientNotFound
+	}
+	client.Disabled = true
+	client.DisabledReason = reason
+	client.DisabledAt = time.Now()
+	r.clients[clientId] = client
+	return nil
+}
+
+func (r *SimpleClientRepository) EnableClient(clientId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[clientId]
+	if !ok {
+		return ErrClientNotFound
+	}
+	client.Disabled = false
+	client.DisabledReason = ""
+	client.DisabledAt = time.Time{}
+	r.clients[clientId] = client
+	return nil
+}
+
+func (r *SimpleClientRepository) DeleteClient(clientId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clients[clientId]; !ok {
+		return ErrClientNotFound
+	}
+	delete(r.clients, clientId)
+	return nil
+}
+
+// stampClient sets the schema version and the timestamps of a client that is saved or updated at now.
+func stampClient(client *Client, now time.Time) {
+	client.SchemaVersion = ClientSchemaVersion
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = now
+	}
+	client.UpdatedAt = now
+}
+
+// clientSecrets returns the secrets of the client, including a secret for its plaintext ClientSecret.
+func clientSecrets(client Client) ([]Secret, error) {
+	if client.ClientSecret == "" {
+		return client.Secrets, nil
+	}
+	newSecret, err := newClientSecret(client.ClientSecret, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return append(slices.Clone(client.Secrets), *newSecret), nil
+}
+
+func newClientSecret(clientSecret string, expiresAt time.Time) (*Secret, error) {
+	hash, err := secret.Hash(clientSecret, secret.ClientSecretParams)
+	if err != nil {
+		return nil, err
+	}
+	return &Secret{
+		SecretId:  randstr.Hex(16),
+		Hash:      hash,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
 	}, nil
 }
 
-func NewSimpleClientRepository() SimpleClientRepository {
-	return SimpleClientRepository{}
+// verifyClientSecret verifies the secret of a client with the token endpoint auth method.
+// Only public clients with the auth method none are identified without secret. For all other clients,
+// an empty secret is rejected with ErrInvalidClientSecret, even if they have no unexpired secrets.
+func verifyClientSecret(authMethod string, secrets []Secret, clientSecret string, now time.Time) (bool, error) {
+	if authMethod == AuthMethodNone {
+		return clientSecret == "", nil
+	}
+	if clientSecret == "" {
+		return false, ErrInvalidClientSecret
+	}
+	return matchClientSecret(secrets, clientSecret, now), nil
+}
+
+// matchClientSecret compares the secret with all unexpired secrets, so that the time does not reveal which one matched.
+func matchClientSecret(secrets []Secret, clientSecret string, now time.Time) bool {
+	matched := false
+	for _, s := range secrets {
+		if !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt) {
+			continue
+		}
+		if secret.Verify(s.Hash, clientSecret) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// NewSimpleClientRepository creates an in-memory client repository, which contains the example client 1234567890.
+func NewSimpleClientRepository() *SimpleClientRepository {
+	repository := &SimpleClientRepository{
+		clients: map[string]Client{},
+	}
+	_, _ = repository.SaveClient(Client{
+		ClientId:           "1234567890",
+		Name:               "Example",
+		ClientSecret:       "client_secret",
+		RedirectUris:       []string{"http://localhost:3000/callback"},
+		Scopes:             []string{"read:example"},
+		AllowIntrospection: true,
+	})
+	return repository
 }
@@ -0,0 +1,16 @@
+package repository_test
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/daschaa/open-idp/internal/repository/repositorytest"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+func TestSimpleClientRepository(t *testing.T) {
+	suite.Run(t, &repositorytest.ClientRepositorySuite{
+		NewRepository: func() repository.ClientRepository {
+			return repository.NewSimpleClientRepository()
+		},
+	})
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+type SimpleKeyRepository struct {
+	mu   sync.Mutex
+	keys map[string]SigningKey
+}
+
+type KeyNotFound struct {
+	KeyId string
+}
+
+func (e KeyNotFound) Error() string {
+	return "key not found"
+}
+
+func (r *SimpleKeyRepository) SaveKey(key SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[key.KeyId] = key
+	return nil
+}
+
+func (r *SimpleKeyRepository) ListKeys() ([]SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]SigningKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (r *SimpleKeyRepository) RetireKey(keyId string, retiredAt time.Time, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.keys[keyId]
+	if !ok {
+		return KeyNotFound{KeyId: keyId}
+	}
+	key.RetiredAt = retiredAt
+	key.ExpiresAt = expiresAt
+	r.keys[keyId] = key
+	return nil
+}
+
+func NewSimpleKeyRepository() *SimpleKeyRepository {
+	return &SimpleKeyRepository{
+		keys: map[string]SigningKey{},
+	}
+}
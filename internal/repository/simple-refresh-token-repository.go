@@ -0,0 +1,70 @@
+package repository
+
+import "sync"
+
+type SimpleRefreshTokenRepository struct {
+	mu       sync.Mutex
+	families map[string]RefreshTokenFamily
+}
+
+type RefreshTokenNotFound struct{}
+
+func (e RefreshTokenNotFound) Error() string {
+	return "refresh token not found"
+}
+
+type RefreshTokenReused struct{}
+
+func (e RefreshTokenReused) Error() string {
+	return "refresh token was already used"
+}
+
+func (r *SimpleRefreshTokenRepository) SaveRefreshTokenFamily(family RefreshTokenFamily) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families[family.FamilyId] = family
+	return nil
+}
+
+func (r *SimpleRefreshTokenRepository) GetRefreshTokenFamily(familyId string) (*RefreshTokenFamily, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	family, ok := r.families[familyId]
+	if !ok {
+		return nil, RefreshTokenNotFound{}
+	}
+	return &family, nil
+}
+
+func (r *SimpleRefreshTokenRepository) RotateRefreshToken(familyId string, previousTokenHash string, tokenHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	family, ok := r.families[familyId]
+	if !ok {
+		return RefreshTokenNotFound{}
+	}
+	if family.Revoked || family.TokenHash != previousTokenHash {
+		return RefreshTokenReused{}
+	}
+	family.TokenHash = tokenHash
+	r.families[familyId] = family
+	return nil
+}
+
+func (r *SimpleRefreshTokenRepository) RevokeRefreshTokenFamily(familyId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	family, ok := r.families[familyId]
+	if !ok {
+		return RefreshTokenNotFound{}
+	}
+	family.Revoked = true
+	r.families[familyId] = family
+	return nil
+}
+
+func NewSimpleRefreshTokenRepository() *SimpleRefreshTokenRepository {
+	return &SimpleRefreshTokenRepository{
+		families: map[string]RefreshTokenFamily{},
+	}
+}
@@ -0,0 +1,28 @@
+package repository
+
+import "sync"
+
+type SimpleRevocationRepository struct {
+	mu      sync.Mutex
+	revoked map[string]RevokedToken
+}
+
+func (r *SimpleRevocationRepository) RevokeToken(token RevokedToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoked[token.TokenId] = token
+	return nil
+}
+
+func (r *SimpleRevocationRepository) IsRevoked(tokenId string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.revoked[tokenId]
+	return ok, nil
+}
+
+func NewSimpleRevocationRepository() *SimpleRevocationRepository {
+	return &SimpleRevocationRepository{
+		revoked: map[string]RevokedToken{},
+	}
+}
@@ -0,0 +1,44 @@
+package repository
+
+import "sync"
+
+type SimpleSessionRepository struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+type SessionNotFound struct{}
+
+func (e SessionNotFound) Error() string {
+	return "session not found"
+}
+
+func (r *SimpleSessionRepository) SaveSession(session Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[session.SessionHash] = session
+	return nil
+}
+
+func (r *SimpleSessionRepository) GetSession(sessionHash string) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[sessionHash]
+	if !ok {
+		return nil, SessionNotFound{}
+	}
+	return &session, nil
+}
+
+func (r *SimpleSessionRepository) DeleteSession(sessionHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, sessionHash)
+	return nil
+}
+
+func NewSimpleSessionRepository() *SimpleSessionRepository {
+	return &SimpleSessionRepository{
+		sessions: map[string]Session{},
+	}
+}
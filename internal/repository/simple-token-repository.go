@@ -0,0 +1,37 @@
+package repository
+
+import "sync"
+
+type SimpleTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]ReferenceToken
+}
+
+type TokenNotFound struct{}
+
+func (e TokenNotFound) Error() string {
+	return "token not found"
+}
+
+func (r *SimpleTokenRepository) SaveToken(token ReferenceToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (r *SimpleTokenRepository) GetToken(tokenHash string) (*ReferenceToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.tokens[tokenHash]
+	if !ok {
+		return nil, TokenNotFound{}
+	}
+	return &token, nil
+}
+
+func NewSimpleTokenRepository() *SimpleTokenRepository {
+	return &SimpleTokenRepository{
+		tokens: map[string]ReferenceToken{},
+	}
+}
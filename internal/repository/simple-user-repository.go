@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+// SimpleUserRepository keeps users in memory.
+type SimpleUserRepository struct {
+	mu    sync.Mutex
+	users map[string]User
+}
+
+type UserNotFound struct {
+	Subject string
+}
+
+func (e UserNotFound) Error() string {
+	return "user not found"
+}
+
+func (r *SimpleUserRepository) SaveUser(user User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.users {
+		if other.Username == user.Username && other.Subject != user.Subject {
+			return ErrUsernameTaken
+		}
+	}
+	r.users[user.Subject] = user
+	return nil
+}
+
+func (r *SimpleUserRepository) GetUser(subject string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[subject]
+	if !ok {
+		return nil, UserNotFound{
+			Subject: subject,
+		}
+	}
+	return &user, nil
+}
+
+func (r *SimpleUserRepository) GetUserByUsername(username string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, UserNotFound{}
+}
+
+func (r *SimpleUserRepository) RecordFailedLogin(subject string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[subject]
+	if !ok {
+		return 0, UserNotFound{Subject: subject}
+	}
+	user.FailedLogins++
+	r.users[subject] = user
+	return user.FailedLogins, nil
+}
+
+func (r *SimpleUserRepository) LockUser(subject string, lockedUntil time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[subject]
+	if !ok {
+		return UserNotFound{Subject: subject}
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = lockedUntil
+	r.users[subject] = user
+	return nil
+}
+
+func (r *SimpleUserRepository) ResetFailedLogins(subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[subject]
+	if !ok {
+		return UserNotFound{Subject: subject}
+	}
+	user.FailedLogins = 0
+	r.users[subject] = user
+	return nil
+}
+
+func NewSimpleUserRepository() *SimpleUserRepository {
+	return &SimpleUserRepository{
+		users: map[string]User{},
+	}
+}
@@ -0,0 +1,16 @@
+package repository_test
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/daschaa/open-idp/internal/repository/repositorytest"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+func TestSimpleUserRepository(t *testing.T) {
+	suite.Run(t, &repositorytest.UserRepositorySuite{
+		NewRepository: func() repository.UserRepository {
+			return repository.NewSimpleUserRepository()
+		},
+	})
+}
@@ -0,0 +1,91 @@
+// Package secret hashes and verifies the passwords of users and the secrets of clients.
+package secret
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Params are the cost parameters of argon2id hashes.
+type Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+var (
+	// PasswordParams follow the second recommended option of RFC 9106 section 4 with the memory reduced to 64 MiB,
+	// so that a Lambda function with little memory can verify them.
+	PasswordParams = Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+	// ClientSecretParams are cheaper, because client secrets are long random strings, which can not be guessed
+	// even with a fast hash, and clients authenticate with every token request.
+	ClientSecretParams = Params{Time: 1, Memory: 4 * 1024, Threads: 1}
+)
+
+const (
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+var errInvalidHash = errors.New("invalid secret hash")
+
+// Hash hashes the secret with argon2id and returns the hash in the PHC string format,
+// which contains the parameters and the salt, so that the parameters can be changed without invalidating existing hashes.
+func Hash(secret string, params Params) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(secret), salt, params.Time, params.Memory, params.Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify reports whether the secret matches the argon2id or bcrypt hash in constant time.
+func Verify(hash string, secret string) bool {
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+	salt, key, err := parseArgon2Hash(hash, &version, &memory, &time, &threads)
+	if err != nil || version != argon2.Version {
+		return false
+	}
+	candidate := argon2.IDKey([]byte(secret), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, candidate) == 1
+}
+
+func parseArgon2Hash(hash string, version *int, memory *uint32, time *uint32, threads *uint8) ([]byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", version); err != nil {
+		return nil, nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", memory, time, threads); err != nil {
+		return nil, nil, errInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, errInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, errInvalidHash
+	}
+	return salt, key, nil
+}
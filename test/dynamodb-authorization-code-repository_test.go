@@ -0,0 +1,73 @@
+package integrationtest
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type dynamoDbAuthorizationCodeSuite struct {
+	suite.Suite
+	repository *repository.DynamoDbAuthorizationCodeRepository
+}
+
+func (suite *dynamoDbAuthorizationCodeSuite) SetupTest() {
+	suite.repository = repository.NewDynamoDbAuthorizationCodeRepository(repository.NewLocalDynamoDbClient())
+}
+
+func TestDynamoDbAuthorizationCodeSuite(t *testing.T) {
+	suite.Run(t, new(dynamoDbAuthorizationCodeSuite))
+}
+
+func (s *dynamoDbAuthorizationCodeSuite) Test_DynamoDbAuthorizationCodeRepository_ConsumeAuthorizationCode() {
+	// given a saved authorization code
+	code := repository.AuthorizationCode{
+		Code:                "abcdefghijklmnopqrstuvwxyz123456",
+		ClientId:            "123456789",
+		Subject:             "123456789",
+		RedirectUri:         "http://localhost:3000/callback",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+		ExpiresAt:           time.Unix(1700000000, 0),
+	}
+	err := s.repository.SaveAuthorizationCode(code)
+	assert.NoError(s.T(), err)
+
+	// when consuming the code
+	consumed, err := s.repository.ConsumeAuthorizationCode(code.Code)
+
+	// then the code is returned once
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), code, *consumed)
+	_, err = s.repository.ConsumeAuthorizationCode(code.Code)
+	assert.ErrorIs(s.T(), err, repository.AuthorizationCodeNotFound{})
+}
+
+func (s *dynamoDbAuthorizationCodeSuite) Test_DynamoDbAuthorizationCodeRepository_GetAuthorizationCode() {
+	// given a saved authorization code
+	code := repository.AuthorizationCode{
+		Code:                "zyxwvutsrqponmlkjihgfedcba654321",
+		ClientId:            "123456789",
+		Subject:             "123456789",
+		RedirectUri:         "http://localhost:3000/callback",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+		ExpiresAt:           time.Unix(1700000000, 0),
+	}
+	err := s.repository.SaveAuthorizationCode(code)
+	assert.NoError(s.T(), err)
+
+	// when getting the code
+	got, err := s.repository.GetAuthorizationCode(code.Code)
+
+	// then the code is returned and can still be consumed
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), code, *got)
+	consumed, err := s.repository.ConsumeAuthorizationCode(code.Code)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), code, *consumed)
+	_, err = s.repository.GetAuthorizationCode(code.Code)
+	assert.ErrorIs(s.T(), err, repository.AuthorizationCodeNotFound{})
+}
@@ -3,13 +3,16 @@ package integrationtest
 import (
 	"context"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/daschaa/open-idp/internal/repository/repositorytest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 type dynamoDbSuite struct {
@@ -27,35 +30,201 @@ func TestDynamoDbSuite(t *testing.T) {
 	suite.Run(t, new(dynamoDbSuite))
 }
 
+func TestDynamoDbClientRepositoryConformance(t *testing.T) {
+	suite.Run(t, &repositorytest.ClientRepositorySuite{
+		NewRepository: func() repository.ClientRepository {
+			return repository.NewDynamoDbClientRepository(repository.NewLocalDynamoDbClient())
+		},
+	})
+}
+
+func (s *dynamoDbSuite) getItem(clientId string) map[string]types.AttributeValue {
+	item, err := s.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("clients"),
+		Key: map[string]types.AttributeValue{
+			"clientId": &types.AttributeValueMemberS{Value: clientId},
+		},
+	})
+	assert.NoError(s.T(), err)
+	return item.Item
+}
+
 func (s *dynamoDbSuite) Test_DynamoDbClientRepository_SaveClient() {
 	// when saving a client
-	savedClient, err := s.repository.SaveClient("123456789", "client_secret")
+	savedClient, err := s.repository.SaveClient(repository.Client{ClientId: "123456789", ClientSecret: "client_secret"})
 
-	// then the client should be saved
+	// then only the hash of the secret should be saved
 	assert.NoError(s.T(), err)
-	item, err := s.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+	item := s.getItem("123456789")
+	assert.NotContains(s.T(), item, "clientSecret")
+	secrets := item["secrets"].(*types.AttributeValueMemberL).Value
+	assert.Len(s.T(), secrets, 1)
+	hash := secrets[0].(*types.AttributeValueMemberM).Value["hash"].(*types.AttributeValueMemberS).Value
+	assert.True(s.T(), strings.HasPrefix(hash, "$argon2id$"))
+	assert.Equal(s.T(), "", savedClient.ClientSecret)
+	assert.Equal(s.T(), hash, savedClient.Secrets[0].Hash)
+}
+
+func (s *dynamoDbSuite) Test_DynamoDbClientRepository_RehashesLegacySecret() {
+	// given a client with a plaintext secret, as saved by earlier versions
+	_, err := s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
 		TableName: aws.String("clients"),
-		Key: map[string]types.AttributeValue{
-			"clientId": &types.AttributeValueMemberS{Value: "123456789"},
+		Item: map[string]types.AttributeValue{
+			"clientId":           &types.AttributeValueMemberS{Value: "legacy"},
+			"clientSecret":       &types.AttributeValueMemberS{Value: "legacy_secret"},
+			"allowIntrospection": &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	assert.NoError(s.T(), err)
+
+	// when the client is read, the plaintext secret is not returned
+	client, err := s.repository.GetClient("legacy")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "", client.ClientSecret)
+
+	// and a wrong secret does not change the row
+	ok, err := s.repository.VerifyClientSecret("legacy", "wrong_secret", time.Now())
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), ok)
+	assert.Contains(s.T(), s.getItem("legacy"), "clientSecret")
+
+	// when the client authenticates with its secret
+	ok, err = s.repository.VerifyClientSecret("legacy", "legacy_secret", time.Now())
+
+	// then the plaintext secret is replaced by its hash, which still matches
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), ok)
+	item := s.getItem("legacy")
+	assert.NotContains(s.T(), item, "clientSecret")
+	assert.Contains(s.T(), item, "secrets")
+	ok, err = s.repository.VerifyClientSecret("legacy", "legacy_secret", time.Now())
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), ok)
+}
+
+func (s *dynamoDbSuite) Test_DynamoDbClientRepository_RejectsNewerSchemaVersion() {
+	// given a client that was saved by a newer version of the server
+	_, err := s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("clients"),
+		Item: map[string]types.AttributeValue{
+			"clientId":           &types.AttributeValueMemberS{Value: "future"},
+			"schemaVersion":      &types.AttributeValueMemberN{Value: "999"},
+			"allowIntrospection": &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	assert.NoError(s.T(), err)
+
+	// when the client is read
+	_, err = s.repository.GetClient("future")
+
+	// then ErrUnsupportedClientSchema is returned instead of a client with misread metadata
+	assert.ErrorIs(s.T(), err, repository.ErrUnsupportedClientSchema)
+}
+
+func (s *dynamoDbSuite) Test_DynamoDbClientRepository_DoesNotUpdateNewerSchemaVersion() {
+	// given a client that was saved by a newer version of the server with an attribute this version does not know
+	_, err := s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("clients"),
+		Item: map[string]types.AttributeValue{
+			"clientId":           &types.AttributeValueMemberS{Value: "future-updated"},
+			"schemaVersion":      &types.AttributeValueMemberN{Value: strconv.Itoa(repository.ClientSchemaVersion + 1)},
+			"allowIntrospection": &types.AttributeValueMemberBOOL{Value: false},
+			"futureAttribute":    &types.AttributeValueMemberS{Value: "future"},
+		},
+	})
+	assert.NoError(s.T(), err)
+
+	// when the client is updated
+	_, err = s.repository.UpdateClient(repository.Client{ClientId: "future-updated", Name: "Downgraded"})
+
+	// then ErrUnsupportedClientSchema is returned and the item is not downgraded
+	assert.ErrorIs(s.T(), err, repository.ErrUnsupportedClientSchema)
+	item := s.getItem("future-updated")
+	assert.Equal(s.T(), &types.AttributeValueMemberN{Value: strconv.Itoa(repository.ClientSchemaVersion + 1)}, item["schemaVersion"])
+	assert.Equal(s.T(), &types.AttributeValueMemberS{Value: "future"}, item["futureAttribute"])
+	assert.NotContains(s.T(), item, "name")
+}
+
+func (s *dynamoDbSuite) Test_DynamoDbClientRepository_ListsClientsNextToNewerSchemaVersion() {
+	// given a client that was saved by a newer version of the server, next to a current client
+	_, err := s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("clients"),
+		Item: map[string]types.AttributeValue{
+			"clientId":           &types.AttributeValueMemberS{Value: "future-listed"},
+			"schemaVersion":      &types.AttributeValueMemberN{Value: "999"},
+			"allowIntrospection": &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	assert.NoError(s.T(), err)
+	_, err = s.repository.SaveClient(repository.Client{ClientId: "current-listed", ClientSecret: "secret"})
+	assert.NoError(s.T(), err)
+
+	// when listing all clients
+	clients, _, err := s.repository.ListClients(0, "")
+
+	// then the current client is listed and the newer one is left out
+	assert.NoError(s.T(), err)
+	ids := []string{}
+	for _, client := range clients {
+		ids = append(ids, client.ClientId)
+	}
+	assert.Contains(s.T(), ids, "current-listed")
+	assert.NotContains(s.T(), ids, "future-listed")
+}
+
+func (s *dynamoDbSuite) Test_DynamoDbClientRepository_ReadsClientWithoutSchemaVersion() {
+	// given a client that was saved before the schema was versioned
+	_, err := s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("clients"),
+		Item: map[string]types.AttributeValue{
+			"clientId":           &types.AttributeValueMemberS{Value: "unversioned"},
+			"scopes":             &types.AttributeValueMemberSS{Value: []string{"read:example"}},
+			"allowIntrospection": &types.AttributeValueMemberBOOL{Value: false},
 		},
 	})
 	assert.NoError(s.T(), err)
-	client := &repository.Client{}
-	err = attributevalue.UnmarshalMap(item.Item, client)
+
+	// when the client is read
+	client, err := s.repository.GetClient("unversioned")
+
+	// then it is returned with version 0 and without restrictions of its grant types
 	assert.NoError(s.T(), err)
-	assert.Equal(s.T(), "123456789", client.ClientId)
-	assert.Equal(s.T(), "client_secret", client.ClientSecret)
-	assert.Equal(s.T(), savedClient, client)
+	assert.Equal(s.T(), 0, client.SchemaVersion)
+	assert.Equal(s.T(), []string{"read:example"}, client.Scopes)
+	assert.True(s.T(), client.AllowsGrantType(repository.GrantTypeClientCredentials))
 }
 
-func (s *dynamoDbSuite) Test_DynamoDbClientRepository_GetClient() {
-	// when getting a saved client
-	savedClient, err := s.repository.SaveClient("123456789", "client_secret")
-	client, err := s.repository.GetClient("123456789")
+func (s *dynamoDbSuite) Test_DynamoDbClientRepository_AcceptsSecretAddedToLegacyClient() {
+	// given a client with a plaintext secret, as saved by earlier versions, and a hashed secret that was added since
+	_, err := s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("clients"),
+		Item: map[string]types.AttributeValue{
+			"clientId":           &types.AttributeValueMemberS{Value: "legacy-rotated"},
+			"clientSecret":       &types.AttributeValueMemberS{Value: "legacy_secret"},
+			"allowIntrospection": &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	assert.NoError(s.T(), err)
+	_, err = s.repository.AddClientSecret("legacy-rotated", "new_secret", time.Time{})
+	assert.NoError(s.T(), err)
+
+	// when the client authenticates with the added secret
+	ok, err := s.repository.VerifyClientSecret("legacy-rotated", "new_secret", time.Now())
+
+	// then the secret is accepted
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), ok)
+
+	// and neither a wrong nor an empty secret is accepted
+	ok, err = s.repository.VerifyClientSecret("legacy-rotated", "wrong_secret", time.Now())
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), ok)
+	ok, err = s.repository.VerifyClientSecret("legacy-rotated", "", time.Now())
+	assert.ErrorIs(s.T(), err, repository.ErrInvalidClientSecret)
+	assert.False(s.T(), ok)
 
-	// then the client should be valid
+	// and the plaintext secret is still accepted
+	ok, err = s.repository.VerifyClientSecret("legacy-rotated", "legacy_secret", time.Now())
 	assert.NoError(s.T(), err)
-	assert.Equal(s.T(), "123456789", client.ClientId)
-	assert.Equal(s.T(), "client_secret", client.ClientSecret)
-	assert.Equal(s.T(), client, savedClient)
+	assert.True(s.T(), ok)
 }
@@ -0,0 +1,65 @@
+package integrationtest
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type dynamoDbKeySuite struct {
+	suite.Suite
+	repository *repository.DynamoDbKeyRepository
+}
+
+func (suite *dynamoDbKeySuite) SetupTest() {
+	suite.repository = repository.NewDynamoDbKeyRepository(repository.NewLocalDynamoDbClient())
+}
+
+func TestDynamoDbKeySuite(t *testing.T) {
+	suite.Run(t, new(dynamoDbKeySuite))
+}
+
+func (s *dynamoDbKeySuite) Test_DynamoDbKeyRepository_RetireKey() {
+	// given a saved key
+	key := repository.SigningKey{
+		KeyId:     "key1234567890",
+		Algorithm: "HS256",
+		Material:  []byte("secret"),
+		CreatedAt: time.Unix(1700000000, 0),
+	}
+	err := s.repository.SaveKey(key)
+	assert.NoError(s.T(), err)
+
+	// when retiring the key
+	err = s.repository.RetireKey(key.KeyId, time.Unix(1700003600, 0), time.Unix(1700090000, 0))
+
+	// then the key is listed as retired
+	assert.NoError(s.T(), err)
+	keys, err := s.repository.ListKeys()
+	assert.NoError(s.T(), err)
+	key.RetiredAt = time.Unix(1700003600, 0)
+	key.ExpiresAt = time.Unix(1700090000, 0)
+	assert.Contains(s.T(), keys, key)
+}
+
+func (s *dynamoDbKeySuite) Test_DynamoDbKeyRepository_StoresEncryptedDataKey() {
+	// given a key with envelope encrypted material
+	key := repository.SigningKey{
+		KeyId:            "key-encrypted",
+		Algorithm:        "ES256",
+		Material:         []byte("ciphertext"),
+		CreatedAt:        time.Unix(1700000000, 0),
+		EncryptedDataKey: []byte("encrypted data key"),
+	}
+
+	// when saving the key
+	err := s.repository.SaveKey(key)
+
+	// then the key is listed with its data key
+	assert.NoError(s.T(), err)
+	keys, err := s.repository.ListKeys()
+	assert.NoError(s.T(), err)
+	assert.Contains(s.T(), keys, key)
+}
@@ -0,0 +1,68 @@
+package integrationtest
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type dynamoDbRefreshTokenSuite struct {
+	suite.Suite
+	repository *repository.DynamoDbRefreshTokenRepository
+}
+
+func (suite *dynamoDbRefreshTokenSuite) SetupTest() {
+	suite.repository = repository.NewDynamoDbRefreshTokenRepository(repository.NewLocalDynamoDbClient())
+}
+
+func TestDynamoDbRefreshTokenSuite(t *testing.T) {
+	suite.Run(t, new(dynamoDbRefreshTokenSuite))
+}
+
+func (s *dynamoDbRefreshTokenSuite) saveFamily() repository.RefreshTokenFamily {
+	family := repository.RefreshTokenFamily{
+		FamilyId:  "family1234567890",
+		TokenHash: "first",
+		ClientId:  "123456789",
+		Subject:   "123456789",
+		Scope:     "read:example",
+		ExpiresAt: time.Unix(1700000000, 0),
+	}
+	err := s.repository.SaveRefreshTokenFamily(family)
+	assert.NoError(s.T(), err)
+	return family
+}
+
+func (s *dynamoDbRefreshTokenSuite) Test_DynamoDbRefreshTokenRepository_RotateRefreshToken() {
+	// given a saved refresh token family
+	family := s.saveFamily()
+
+	// when rotating the refresh token
+	err := s.repository.RotateRefreshToken(family.FamilyId, "first", "second")
+
+	// then the new token hash is stored and the old one can not be rotated again
+	assert.NoError(s.T(), err)
+	saved, err := s.repository.GetRefreshTokenFamily(family.FamilyId)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "second", saved.TokenHash)
+	err = s.repository.RotateRefreshToken(family.FamilyId, "first", "third")
+	assert.ErrorIs(s.T(), err, repository.RefreshTokenReused{})
+}
+
+func (s *dynamoDbRefreshTokenSuite) Test_DynamoDbRefreshTokenRepository_RevokeRefreshTokenFamily() {
+	// given a saved refresh token family
+	family := s.saveFamily()
+
+	// when revoking the family
+	err := s.repository.RevokeRefreshTokenFamily(family.FamilyId)
+
+	// then the family is revoked and can no longer be rotated
+	assert.NoError(s.T(), err)
+	saved, err := s.repository.GetRefreshTokenFamily(family.FamilyId)
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), saved.Revoked)
+	err = s.repository.RotateRefreshToken(family.FamilyId, "first", "second")
+	assert.ErrorIs(s.T(), err, repository.RefreshTokenReused{})
+}
@@ -0,0 +1,47 @@
+package integrationtest
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type dynamoDbRevocationSuite struct {
+	suite.Suite
+	repository *repository.DynamoDbRevocationRepository
+}
+
+func (suite *dynamoDbRevocationSuite) SetupTest() {
+	suite.repository = repository.NewDynamoDbRevocationRepository(repository.NewLocalDynamoDbClient())
+}
+
+func TestDynamoDbRevocationSuite(t *testing.T) {
+	suite.Run(t, new(dynamoDbRevocationSuite))
+}
+
+func (s *dynamoDbRevocationSuite) Test_DynamoDbRevocationRepository_RevokeToken() {
+	// when revoking a token
+	err := s.repository.RevokeToken(repository.RevokedToken{
+		TokenId:   "jti1234567890",
+		ClientId:  "123456789",
+		RevokedAt: time.Unix(1700000000, 0),
+		ExpiresAt: time.Unix(1700003600, 0),
+	})
+
+	// then the token is revoked
+	assert.NoError(s.T(), err)
+	revoked, err := s.repository.IsRevoked("jti1234567890")
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), revoked)
+}
+
+func (s *dynamoDbRevocationSuite) Test_DynamoDbRevocationRepository_IsRevokedReturnsFalseForUnknownToken() {
+	// when looking up a token that was never revoked
+	revoked, err := s.repository.IsRevoked("unknown")
+
+	// then the token is not revoked
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), revoked)
+}
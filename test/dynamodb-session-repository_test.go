@@ -0,0 +1,57 @@
+package integrationtest
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type dynamoDbSessionSuite struct {
+	suite.Suite
+	repository *repository.DynamoDbSessionRepository
+}
+
+func (suite *dynamoDbSessionSuite) SetupTest() {
+	suite.repository = repository.NewDynamoDbSessionRepository(repository.NewLocalDynamoDbClient())
+}
+
+func TestDynamoDbSessionSuite(t *testing.T) {
+	suite.Run(t, new(dynamoDbSessionSuite))
+}
+
+func (s *dynamoDbSessionSuite) Test_DynamoDbSessionRepository_GetSession() {
+	// given a saved session
+	session := repository.Session{
+		SessionHash: "hash1234567890",
+		Subject:     "alice",
+		AuthTime:    time.Unix(1699990000, 0),
+		ExpiresAt:   time.Unix(1700000000, 0),
+	}
+	err := s.repository.SaveSession(session)
+	assert.NoError(s.T(), err)
+
+	// when getting the session
+	saved, err := s.repository.GetSession("hash1234567890")
+
+	// then the session is returned
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "alice", saved.Subject)
+	assert.True(s.T(), saved.AuthTime.Equal(session.AuthTime))
+	assert.True(s.T(), saved.ExpiresAt.Equal(session.ExpiresAt))
+}
+
+func (s *dynamoDbSessionSuite) Test_DynamoDbSessionRepository_DeleteSession() {
+	// given a saved session
+	err := s.repository.SaveSession(repository.Session{SessionHash: "hash_deleted", Subject: "alice"})
+	assert.NoError(s.T(), err)
+
+	// when deleting the session
+	err = s.repository.DeleteSession("hash_deleted")
+
+	// then the session is not found anymore
+	assert.NoError(s.T(), err)
+	_, err = s.repository.GetSession("hash_deleted")
+	assert.ErrorAs(s.T(), err, &repository.SessionNotFound{})
+}
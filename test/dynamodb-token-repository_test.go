@@ -0,0 +1,48 @@
+package integrationtest
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type dynamoDbTokenSuite struct {
+	suite.Suite
+	repository *repository.DynamoDbTokenRepository
+}
+
+func (suite *dynamoDbTokenSuite) SetupTest() {
+	suite.repository = repository.NewDynamoDbTokenRepository(repository.NewLocalDynamoDbClient())
+}
+
+func TestDynamoDbTokenSuite(t *testing.T) {
+	suite.Run(t, new(dynamoDbTokenSuite))
+}
+
+func (s *dynamoDbTokenSuite) Test_DynamoDbTokenRepository_GetToken() {
+	// given a saved reference token
+	token := repository.ReferenceToken{
+		TokenHash: "hash1234567890",
+		Claims:    []byte(`{"sub":"123456789","scope":"read:example"}`),
+		ExpiresAt: time.Unix(1700000000, 0),
+	}
+	err := s.repository.SaveToken(token)
+	assert.NoError(s.T(), err)
+
+	// when getting the token
+	saved, err := s.repository.GetToken("hash1234567890")
+
+	// then its claims and expiry are returned
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), &token, saved)
+}
+
+func (s *dynamoDbTokenSuite) Test_DynamoDbTokenRepository_GetTokenReturnsNotFound() {
+	// when getting a token that was never saved
+	_, err := s.repository.GetToken("unknown")
+
+	// then the token is not found
+	assert.ErrorIs(s.T(), err, repository.TokenNotFound{})
+}
@@ -0,0 +1,114 @@
+package integrationtest
+
+import (
+	"github.com/daschaa/open-idp/internal/repository"
+	"github.com/daschaa/open-idp/internal/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type dynamoDbUserSuite struct {
+	suite.Suite
+	repository *repository.DynamoDbUserRepository
+}
+
+func (suite *dynamoDbUserSuite) SetupTest() {
+	suite.repository = repository.NewDynamoDbUserRepository(repository.NewLocalDynamoDbClient())
+}
+
+func TestDynamoDbUserSuite(t *testing.T) {
+	suite.Run(t, new(dynamoDbUserSuite))
+}
+
+func TestDynamoDbUserRepositoryConformance(t *testing.T) {
+	suite.Run(t, &repositorytest.UserRepositorySuite{
+		NewRepository: func() repository.UserRepository {
+			return repository.NewDynamoDbUserRepository(repository.NewLocalDynamoDbClient())
+		},
+	})
+}
+
+func (s *dynamoDbUserSuite) Test_DynamoDbUserRepository_GetUserByUsername() {
+	// given a saved user
+	user := repository.User{
+		Subject:      "subject1234567890",
+		Username:     "alice",
+		Email:        "alice@example.com",
+		PasswordHash: "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$a2V5",
+		Attributes:   map[string]string{"department": "engineering"},
+	}
+	err := s.repository.SaveUser(user)
+	assert.NoError(s.T(), err)
+
+	// when getting the user by its username
+	saved, err := s.repository.GetUserByUsername("alice")
+
+	// then the user is returned
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "subject1234567890", saved.Subject)
+	assert.Equal(s.T(), user.PasswordHash, saved.PasswordHash)
+	assert.Equal(s.T(), user.Attributes, saved.Attributes)
+}
+
+func (s *dynamoDbUserSuite) Test_DynamoDbUserRepository_CountsFailedLogins() {
+	// given a saved user
+	err := s.repository.SaveUser(repository.User{Subject: "subject_failed_logins", Username: "bob"})
+	assert.NoError(s.T(), err)
+
+	// when recording two failed logins
+	_, err = s.repository.RecordFailedLogin("subject_failed_logins")
+	assert.NoError(s.T(), err)
+	failedLogins, err := s.repository.RecordFailedLogin("subject_failed_logins")
+
+	// then the failed logins are counted
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, failedLogins)
+}
+
+func (s *dynamoDbUserSuite) Test_DynamoDbUserRepository_LockUser() {
+	// given a saved user with a failed login
+	err := s.repository.SaveUser(repository.User{Subject: "subject_locked", Username: "carol"})
+	assert.NoError(s.T(), err)
+	_, err = s.repository.RecordFailedLogin("subject_locked")
+	assert.NoError(s.T(), err)
+
+	// when locking the user
+	err = s.repository.LockUser("subject_locked", time.Unix(1700000000, 0))
+
+	// then the user is locked and the failed logins are reset
+	assert.NoError(s.T(), err)
+	saved, err := s.repository.GetUser("subject_locked")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 0, saved.FailedLogins)
+	assert.True(s.T(), saved.LockedUntil.Equal(time.Unix(1700000000, 0)))
+}
+
+func (s *dynamoDbUserSuite) Test_DynamoDbUserRepository_GetUserReturnsNotFound() {
+	// when getting a user that was never saved
+	_, err := s.repository.GetUser("unknown")
+
+	// then the user is not found
+	assert.ErrorAs(s.T(), err, &repository.UserNotFound{})
+}
+
+func (s *dynamoDbUserSuite) Test_DynamoDbUserRepository_RejectsSubjectOfUsernameReservation() {
+	// given a saved user, whose username is reserved by another item
+	err := s.repository.SaveUser(repository.User{Subject: "subject_reserved_username", Username: "carol"})
+	assert.NoError(s.T(), err)
+
+	// when getting or saving a user with the subject of the reservation
+	_, getErr := s.repository.GetUser("username#carol")
+	saveErr := s.repository.SaveUser(repository.User{Subject: "username#carol", Username: "mallory"})
+	_, recordErr := s.repository.RecordFailedLogin("username#carol")
+
+	// then the reservation is not treated as a user
+	assert.ErrorAs(s.T(), getErr, &repository.UserNotFound{})
+	assert.ErrorIs(s.T(), saveErr, repository.ErrReservedSubject)
+	assert.ErrorAs(s.T(), recordErr, &repository.UserNotFound{})
+
+	// and the username stays reserved for its user
+	err = s.repository.SaveUser(repository.User{Subject: "subject_other_user", Username: "carol"})
+	assert.ErrorIs(s.T(), err, repository.ErrUsernameTaken)
+}